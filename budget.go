@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// budgetLimits caps a run; a zero value means no cap.
+type budgetLimits struct {
+	MaxRunCost       float64
+	MaxRunTokens     int64
+	MaxSubjectCost   float64
+	MaxSubjectTokens int64
+}
+
+func (l budgetLimits) enabled() bool {
+	return l.MaxRunCost > 0 || l.MaxRunTokens > 0 || l.MaxSubjectCost > 0 || l.MaxSubjectTokens > 0
+}
+
+// skippedCell is a generation cell, or a call of a later stage, that the
+// caps did not allow.
+type skippedCell struct {
+	Phase       string
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	LLMName     string `json:",omitempty"`
+	Reason      string
+}
+
+// budgetGuard decides whether another generation cell may be dispatched. The
+// projection is the actual spend so far, plus the cells in flight and the
+// candidate cell, each at the average cost of a finished cell including its
+// validation. Before any cell has finished, a prompt size estimate is used.
+// The calls of the later stages, such as judging, are admitted one by one on
+// the estimate of their own prompt, reserved until they return.
+type budgetGuard struct {
+	mu             sync.Mutex
+	limits         budgetLimits
+	ledger         *usageLedger
+	prices         map[string]modelPrice
+	inFlight       map[string]int
+	reservedCost   map[string]float64
+	reservedTokens map[string]int64
+	finished       int
+	skipped        []skippedCell
+}
+
+func newBudgetGuard(limits budgetLimits, ledger *usageLedger, prices map[string]modelPrice) *budgetGuard {
+	return &budgetGuard{
+		limits:         limits,
+		ledger:         ledger,
+		prices:         prices,
+		inFlight:       make(map[string]int),
+		reservedCost:   make(map[string]float64),
+		reservedTokens: make(map[string]int64),
+	}
+}
+
+// priorCellEstimate prices one generation call with its validation call from
+// the prompt sizes alone.
+func (b *budgetGuard) priorCellEstimate(chanInput []string) (float64, int64) {
+
+	generationPrompt := int64(estimateTokens(systemPrompt,
+		getPromptRefined(assessmentBankCount, chanInput[0], chanInput[1], chanInput[2], chanInput[3], generationLLMName)))
+	generationOutput := int64(expectedOutputTokens)
+	validationPrompt := int64(estimateTokens(systemPromptForValidation)) + generationOutput
+	validationOutput := int64(expectedOutputTokens / 2)
+
+	cost := b.prices[generationLLMName].cost(generationPrompt, generationOutput) +
+		b.prices[validationLLMName].cost(validationPrompt, validationOutput)
+
+	return cost, generationPrompt + generationOutput + validationPrompt + validationOutput
+}
+
+func (b *budgetGuard) cellEstimate(chanInput []string, actual tokenUsage) (float64, int64) {
+	if b.finished == 0 {
+		return b.priorCellEstimate(chanInput)
+	}
+	return actual.Cost / float64(b.finished), (actual.PromptTokens + actual.CandidateTokens) / int64(b.finished)
+}
+
+// overCap tells which cap the projected run and subject spend would break,
+// or returns an empty string. The reservations of review calls in flight are
+// added to the projections.
+func (b *budgetGuard) overCap(subject string, runCost float64, runTokens int64, subjectCost float64, subjectTokens int64) string {
+
+	for reservedSubject, cost := range b.reservedCost {
+		runCost += cost
+		runTokens += b.reservedTokens[reservedSubject]
+	}
+	subjectCost += b.reservedCost[subject]
+	subjectTokens += b.reservedTokens[subject]
+
+	if b.limits.MaxRunCost > 0 && runCost > b.limits.MaxRunCost {
+		return fmt.Sprintf("run cost projected at $%.4f exceeds $%.4f", runCost, b.limits.MaxRunCost)
+	}
+	if b.limits.MaxRunTokens > 0 && runTokens > b.limits.MaxRunTokens {
+		return fmt.Sprintf("run tokens projected at %d exceed %d", runTokens, b.limits.MaxRunTokens)
+	}
+	if b.limits.MaxSubjectCost > 0 && subjectCost > b.limits.MaxSubjectCost {
+		return fmt.Sprintf("%s cost projected at $%.4f exceeds $%.4f", subject, subjectCost, b.limits.MaxSubjectCost)
+	}
+	if b.limits.MaxSubjectTokens > 0 && subjectTokens > b.limits.MaxSubjectTokens {
+		return fmt.Sprintf("%s tokens projected at %d exceed %d", subject, subjectTokens, b.limits.MaxSubjectTokens)
+	}
+
+	return ""
+}
+
+// admit reserves room for one more generation cell, or returns why the cell
+// has to be skipped.
+func (b *budgetGuard) admit(chanInput []string) (bool, string) {
+
+	if b == nil || !b.limits.enabled() {
+		return true, ""
+	}
+
+	subject := chanInput[2]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	runActual := b.ledger.total(nil)
+	cellCost, cellTokens := b.cellEstimate(chanInput, runActual)
+
+	runInFlight := 0
+	for _, count := range b.inFlight {
+		runInFlight += count
+	}
+
+	subjectActual := b.ledger.total(func(u tokenUsage) bool { return u.Subject == subject })
+
+	reason := b.overCap(subject,
+		runActual.Cost+float64(runInFlight+1)*cellCost,
+		runActual.PromptTokens+runActual.CandidateTokens+int64(runInFlight+1)*cellTokens,
+		subjectActual.Cost+float64(b.inFlight[subject]+1)*cellCost,
+		subjectActual.PromptTokens+subjectActual.CandidateTokens+int64(b.inFlight[subject]+1)*cellTokens)
+	if reason != "" {
+		return false, reason
+	}
+
+	b.inFlight[subject]++
+
+	return true, ""
+}
+
+// callEstimate prices one review call, [prompt, subject, topic, proficiency,
+// complexity, model], from its prompt with the expected output.
+func (b *budgetGuard) callEstimate(chanInput []string) (float64, int64) {
+
+	prompt := int64(estimateTokens(chanInput[0]))
+	output := int64(expectedOutputTokens)
+
+	return b.prices[chanInput[5]].cost(prompt, output), prompt + output
+}
+
+// admitCall reserves room for one review call, or returns why the call has
+// to be skipped.
+func (b *budgetGuard) admitCall(chanInput []string) (bool, string) {
+
+	if b == nil || !b.limits.enabled() {
+		return true, ""
+	}
+
+	subject := chanInput[1]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	callCost, callTokens := b.callEstimate(chanInput)
+	runActual := b.ledger.total(nil)
+	subjectActual := b.ledger.total(func(u tokenUsage) bool { return u.Subject == subject })
+
+	reason := b.overCap(subject,
+		runActual.Cost+callCost, runActual.PromptTokens+runActual.CandidateTokens+callTokens,
+		subjectActual.Cost+callCost, subjectActual.PromptTokens+subjectActual.CandidateTokens+callTokens)
+	if reason != "" {
+		return false, reason
+	}
+
+	b.reservedCost[subject] += callCost
+	b.reservedTokens[subject] += callTokens
+
+	return true, ""
+}
+
+// releaseCall ends the reservation of an admitted review call once it has
+// returned and its usage is in the ledger.
+func (b *budgetGuard) releaseCall(chanInput []string) {
+
+	if b == nil || !b.limits.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	callCost, callTokens := b.callEstimate(chanInput)
+	b.reservedCost[chanInput[1]] -= callCost
+	b.reservedTokens[chanInput[1]] -= callTokens
+}
+
+// release ends the reservation of an admitted cell once its generation call
+// is over; finished tells whether it produced a batch that counts towards the
+// average cell cost.
+func (b *budgetGuard) release(subject string, finished bool) {
+
+	if b == nil || !b.limits.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.inFlight[subject] > 0 {
+		b.inFlight[subject]--
+	}
+	if finished {
+		b.finished++
+	}
+}
+
+// restored counts cells finished by an earlier session of the run.
+func (b *budgetGuard) restored(count int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.finished += count
+	b.mu.Unlock()
+}
+
+func (b *budgetGuard) skip(cell skippedCell) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.skipped = append(b.skipped, cell)
+}
+
+func (b *budgetGuard) skippedCells() []skippedCell {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]skippedCell(nil), b.skipped...)
+}
+
+type budgetReport struct {
+	Limits    budgetLimits
+	Actual    tokenUsage
+	BySubject map[string]tokenUsage
+	Skipped   []skippedCell
+}
+
+// report explains which cells and calls the caps skipped. Nothing is written
+// when the run had no caps.
+func (b *budgetGuard) report(fileName string) {
+
+	if b == nil || !b.limits.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	report := budgetReport{
+		Limits:    b.limits,
+		Actual:    b.ledger.total(nil),
+		BySubject: make(map[string]tokenUsage),
+		Skipped:   append([]skippedCell(nil), b.skipped...),
+	}
+	b.mu.Unlock()
+
+	for _, usage := range b.ledger.all() {
+		subjectUsage := report.BySubject[usage.Subject]
+		subjectUsage.Subject = usage.Subject
+		subjectUsage.add(usage)
+		report.BySubject[usage.Subject] = subjectUsage
+	}
+
+	fmt.Println("Budget Report")
+	fmt.Println("----------------------------------------------------")
+	fmt.Printf("Spent $%.4f  tokens %d  skipped %d\n", report.Actual.Cost,
+		report.Actual.PromptTokens+report.Actual.CandidateTokens, len(report.Skipped))
+	for _, cell := range report.Skipped {
+		fmt.Println("Skipped", cell.Phase, cell.Subject, cell.Topic, cell.Proficiency, cell.Complexity, cell.LLMName, ":", cell.Reason)
+	}
+	fmt.Println("----------------------------------------------------")
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		slog.Error("budget report not written", "file", fileName, "err", err)
+		return
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		slog.Error("budget report not written", "file", fileName, "err", err)
+	}
+}
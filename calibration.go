@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const phaseCalibration = "calibration"
+
+var complexityRank = map[string]int{"easy": 0, "medium": 1, "difficult": 2}
+
+// calibrationMinProbes is how many probes have to answer a question before
+// its label is judged; a single model is no evidence of difficulty.
+const calibrationMinProbes = 2
+
+// probeStrength ranks a probe model: 1 for the small models such as
+// gemini-1.5-flash-8b, 3 for the pro models and 2 for the others.
+func probeStrength(llmName string) int {
+	switch {
+	case strings.Contains(llmName, "-8b"), strings.Contains(llmName, "-lite"):
+		return 1
+	case strings.Contains(llmName, "-pro"):
+		return 3
+	}
+	return 2
+}
+
+// probeWeight is how much an answer of a probe tells about difficulty. A
+// weak model answering correctly says more about an easy question than a
+// strong one does, and a strong model failing says more about a hard one.
+func probeWeight(llmName string, correct bool) int {
+	if correct {
+		return 4 - probeStrength(llmName)
+	}
+	return probeStrength(llmName)
+}
+
+// empiricalComplexity turns the weighted share of probes answering correctly
+// into a complexity label: more than two thirds is Easy, less than a third
+// Difficult.
+func empiricalComplexity(share float64) string {
+	switch {
+	case share > 2.0/3.0:
+		return complexityList[0]
+	case share >= 1.0/3.0:
+		return complexityList[1]
+	default:
+		return complexityList[2]
+	}
+}
+
+type calibrationSummary struct {
+	ProbeModels     []string
+	LabelMismatches int
+	Flagged         int
+	Relabeled       int
+}
+
+// workerforReview runs the calls of the stages that follow validation, such
+// as calibration and judging. Each input is [prompt, subject, topic,
+// proficiency, complexity, model]; newModel builds the model of the stage.
+func workerforReview(ctx context.Context, tracker chan empty, chanInputs chan []string, responses chan llmResponse, phase string,
+	newModel func(*genai.Client, string) *genai.GenerativeModel, svc *runServices) {
+
+	calls := make(map[string]llmCall)
+
+	for chanInput := range chanInputs {
+
+		llmName := chanInput[5]
+		call, ok := calls[llmName]
+		if !ok {
+			call = llmCall{
+				Model:    newModel(svc.client, llmName),
+				LLMName:  llmName,
+				Phase:    phase,
+				Policy:   svc.policy,
+				Limiter:  svc.limiters.forModel(llmName),
+				Metrics:  svc.metrics,
+				Progress: svc.progress,
+			}
+			calls[llmName] = call
+		}
+
+		logger := cellLogger(svc.logger, phase, llmName, chanInput[1], chanInput[2], chanInput[3], chanInput[4])
+		call.Subject = chanInput[1]
+		call.Logger = logger
+
+		resp, stats, class, err := generateWithRetry(ctx, call, chanInput[0])
+		svc.ledger.add(phase, chanInput[1], chanInput[2], llmName, stats)
+		if err != nil {
+			logger.Error("review call failed", "attempt", stats.Attempts, "class", class.Class.String(),
+				"reason", class.Reason, "err", err)
+			svc.failures.add(failedCell{Phase: phase, Subject: chanInput[1], Topic: chanInput[2], Proficiency: chanInput[3],
+				Complexity: chanInput[4], Attempts: stats.Attempts, Class: class.Class, Reason: class.Reason, Err: err})
+			if class.Class == errorClassCanceled {
+				continue
+			}
+			resp = nil
+		}
+
+		responses <- llmResponse{Input: chanInput, Resp: resp, Stats: stats}
+	}
+
+	tracker <- empty{}
+}
+
+// runReview sends the pending inputs of a review stage through a worker pool
+// sized by the concurrency of the models involved and hands every response
+// to collect, from this goroutine. Each input is admitted by the budget
+// guard first; those the caps do not allow are skipped.
+func (s *scheduler) runReview(ctx context.Context, phase string, newModel func(*genai.Client, string) *genai.GenerativeModel,
+	models []string, pending [][]string, collect func(llmResponse)) {
+
+	if len(pending) == 0 {
+		return
+	}
+
+	workerCount := 0
+	for _, llmName := range models {
+		workerCount += s.svc.limiters.concurrency(llmName)
+	}
+
+	tracker := make(chan empty)
+	chanInputs := make(chan []string)
+	responses := make(chan llmResponse)
+
+	for i := 0; i < workerCount; i++ {
+		go workerforReview(ctx, tracker, chanInputs, responses, phase, newModel, s.svc)
+	}
+
+	go func() {
+		defer close(chanInputs)
+		for _, input := range pending {
+			if ok, reason := s.svc.budget.admitCall(input); !ok {
+				s.svc.budget.skip(skippedCell{Phase: phase, Subject: input[1], Topic: input[2], Proficiency: input[3],
+					Complexity: input[4], LLMName: input[5], Reason: reason})
+				continue
+			}
+			select {
+			case chanInputs <- input:
+			case <-ctx.Done():
+				s.svc.budget.releaseCall(input)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for i := 0; i < workerCount; i++ {
+			<-tracker
+		}
+		close(responses)
+	}()
+
+	for r := range responses {
+		s.svc.budget.releaseCall(r.Input)
+		collect(r)
+	}
+}
+
+// probeBatches has every probe model answer the validation batches of all
+// topics, reusing the answers journaled by an earlier session. It returns
+// the answer of each model per topic and question.
+func (s *scheduler) probeBatches(ctx context.Context, models []string) map[string]map[string]map[string]string {
+
+	answers := make(map[string]map[string]map[string]string)
+	record := func(topicName string, llmName string, validated []assessmentValidatedData) {
+		if answers[topicName] == nil {
+			answers[topicName] = make(map[string]map[string]string)
+		}
+		for _, v := range validated {
+			if answers[topicName][v.Question] == nil {
+				answers[topicName][v.Question] = make(map[string]string)
+			}
+			answers[topicName][v.Question][llmName] = v.ValidatedAnswer
+		}
+	}
+
+	var pending [][]string
+	s.mu.Lock()
+	for _, name := range s.topicOrder {
+		for _, batch := range s.topics[name].Batches {
+			if batch.Prompt == "" {
+				continue
+			}
+			for _, llmName := range models {
+				key := cellKey(batch.Subject, batch.Topic, batch.Proficiency, batch.Complexity)
+				if entry, ok := s.svc.journal.calibrationDone(key, llmName); ok {
+					s.svc.ledger.add(phaseCalibration, entry.Subject, entry.Topic, llmName, entry.Usage)
+					record(name, llmName, entry.Validated)
+					continue
+				}
+				pending = append(pending, []string{batch.Prompt, batch.Subject, batch.Topic, batch.Proficiency, batch.Complexity, llmName})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	s.runReview(ctx, phaseCalibration, newValidationModel, models, pending, func(r llmResponse) {
+		if r.Resp == nil {
+			return
+		}
+		logger := cellLogger(s.svc.logger, phaseCalibration, r.Input[5], r.Input[1], r.Input[2], r.Input[3], r.Input[4])
+		validated := getAllValidatedResponseMap(logger, r.Resp)
+		if len(validated) == 0 {
+			return
+		}
+		err := s.svc.journal.record(journalEntry{Kind: journalKindCalibration, Subject: r.Input[1], Topic: r.Input[2],
+			Proficiency: r.Input[3], Complexity: r.Input[4], Validated: slices.Collect(maps.Values(validated)),
+			LLMName: r.Input[5], Usage: r.Stats})
+		if err != nil {
+			logger.Error("journal write failed", "err", err)
+		}
+		record(r.Input[1]+"-"+r.Input[2], r.Input[5], slices.Collect(maps.Values(validated)))
+	})
+
+	return answers
+}
+
+// calibrate checks the complexity label of every validated question against
+// the cell it was requested for and against which probes answered it
+// correctly: the validator, plus each of models when given, weighted by
+// probeWeight. Labels the evidence contradicts are flagged, and relabeled
+// when relabel is set. Below calibrationMinProbes probes nothing is judged,
+// and with fewer than three only a two step contradiction counts.
+func (s *scheduler) calibrate(ctx context.Context, models []string, relabel bool) {
+
+	var answers map[string]map[string]map[string]string
+	if len(models) > 0 && ctx.Err() == nil {
+		answers = s.probeBatches(ctx, models)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mismatched, flagged, relabeled := 0, 0, 0
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+
+		for question, v := range topic.Final {
+			var notes []string
+
+			if v.RequestedComplexity != "" && !strings.EqualFold(v.RequestedComplexity, v.Complexity) {
+				notes = append(notes, fmt.Sprintf("returned %s for a %s cell", v.Complexity, v.RequestedComplexity))
+				mismatched++
+			}
+
+			correct, probes := 0, 0
+			correctWeight, totalWeight := 0, 0
+			probe := func(llmName string, right bool) {
+				probes++
+				totalWeight += probeWeight(llmName, right)
+				if right {
+					correct++
+					correctWeight += probeWeight(llmName, right)
+				}
+			}
+			if validationVerdict(v) != verdictUnvalidated {
+				probe(validationLLMName, validationVerdict(v) == verdictAgreed)
+			}
+			for _, llmName := range models {
+				if answer, ok := answers[name][question][llmName]; ok {
+					probe(llmName, answer == v.Answer)
+				}
+			}
+
+			label, known := complexityRank[strings.ToLower(v.Complexity)]
+			if probes >= calibrationMinProbes {
+				v.EmpiricalComplexity = empiricalComplexity(float64(correctWeight) / float64(totalWeight))
+				distance := complexityRank[strings.ToLower(v.EmpiricalComplexity)] - label
+				if distance < 0 {
+					distance = -distance
+				}
+				if known && (distance >= 2 || (distance == 1 && probes >= 3)) {
+					notes = append(notes, fmt.Sprintf("%d/%d probes correct, weighted %.2f, suggest %s", correct, probes,
+						float64(correctWeight)/float64(totalWeight), v.EmpiricalComplexity))
+					flagged++
+					if relabel {
+						notes = append(notes, "relabeled from "+v.Complexity)
+						v.Complexity = v.EmpiricalComplexity
+						relabeled++
+					}
+				}
+			}
+
+			v.Calibration = strings.Join(notes, ", ")
+			topic.Final[question] = v
+		}
+
+		if err := csvWriteStringFile(topic.Final, topic.name()+"-"+"ValidatedAssessment.csv"); err != nil {
+			s.svc.logger.Error("validated assessment file not written", "subject", topic.Record[0], "topic", topic.Record[1], "err", err)
+		}
+	}
+
+	s.calibration = calibrationSummary{ProbeModels: models, LabelMismatches: mismatched, Flagged: flagged, Relabeled: relabeled}
+
+	s.svc.logger.Info("complexity calibration done", "probe_models", models, "label_mismatches", mismatched,
+		"flagged", flagged, "relabeled", relabeled)
+}
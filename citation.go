@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const phaseCitation = "citation"
+
+// Verdicts of the entailment check.
+const (
+	citationSupported    = "Supported"
+	citationNotSupported = "NotSupported"
+	citationContradicted = "Contradicted"
+)
+
+var systemPromptForCitation = `You are a meticulous fact checker. You will be given a passage quoted from a reference document and
+multiple choice questions that cite it. Decide for each question only from what the passage states, not from what you know.`
+
+// citationCheck is the entailment verdict on one question and its cited chunk.
+type citationCheck struct {
+	Question string
+	Verdict  string
+	Evidence string
+}
+
+type citationSummary struct {
+	Checked      int
+	Supported    int
+	Unsupported  int
+	Contradicted int
+	Fabricated   int
+	// Disagreements counts the checks where the overlap and the model differ
+	Disagreements int
+	// Unchecked counts the citations left to the overlap alone although a
+	// model was set, because its check failed, was skipped or did not parse
+	Unchecked int
+}
+
+func newCitationModel(client *genai.Client, llmName string) *genai.GenerativeModel {
+
+	model := client.GenerativeModel(llmName)
+	model.ResponseMIMEType = "application/json"
+	const ChatTemperature float32 = 0.0
+	temperature := ChatTemperature
+	model.Temperature = &temperature
+
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(systemPromptForCitation)},
+	}
+
+	return model
+}
+
+func getPromptForCitation(chunk sourceChunk, allQuizes []assessmentDataforMap) string {
+
+	var stepsSequencePrompt string
+
+	stepsPrompt := `
+	Question %d:
+	%s
+	Answer: %s`
+
+	for idx, quiz := range allQuizes {
+		stepsSequencePrompt += fmt.Sprintf(stepsPrompt, idx, quiz.Question, quiz.Answer)
+	}
+
+	return fmt.Sprintf(`
+	The following passage is quoted from %s, delimited by $$$
+	$$$
+	%s
+	$$$
+
+	For each of the following Questions decide whether the passage supports that the Answer is the right Answer to the Question
+	%s
+
+	Return the results using this JSON schema:
+	Check = {
+	'Question' : string
+	'Verdict': string, Supported when the passage states it, Contradicted when the passage states otherwise, NotSupported when the passage does not say
+	'Evidence': string, the sentence of the passage the Verdict rests on, or None
+	}
+	Return: Array<Check>`, chunk.Reference, chunk.Text, stepsSequencePrompt)
+}
+
+func getAllCitationResponseMap(logger *slog.Logger, resp *genai.GenerateContentResponse) map[string]citationCheck {
+
+	checkedMap := make(map[string]citationCheck)
+
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			txt, ok := part.(genai.Text)
+			if !ok {
+				continue
+			}
+			var dataString []citationCheck
+			if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
+				logger.Warn("unparseable citation response", "err", err)
+				continue
+			}
+			for _, check := range dataString {
+				switch check.Verdict {
+				case citationSupported, citationNotSupported, citationContradicted:
+					checkedMap[check.Question] = check
+				}
+			}
+		}
+	}
+
+	return checkedMap
+}
+
+// chunk looks a chunk up by its ID.
+func (x *sourceIndex) chunk(id string) (sourceChunk, bool) {
+
+	if x == nil {
+		return sourceChunk{}, false
+	}
+	idx := slices.IndexFunc(x.chunks, func(c sourceChunk) bool { return c.ID == id })
+	if idx < 0 {
+		return sourceChunk{}, false
+	}
+
+	return x.chunks[idx], true
+}
+
+// citationOverlap is the share of the distinct words of the question and its
+// answer found in the chunk.
+func citationOverlap(chunk sourceChunk, v assessmentDataforMap) float64 {
+
+	words := slices.Compact(slices.Sorted(slices.Values(normalizedTokens(v.Question + " " + v.Answer))))
+	if len(words) == 0 {
+		return 0
+	}
+
+	found := 0
+	for _, word := range words {
+		if chunk.terms[word] > 0 {
+			found++
+		}
+	}
+
+	return float64(found) / float64(len(words))
+}
+
+// verifyCitations checks that the chunk each kept question cites supports its
+// answer: by lexical overlap, and by an entailment check of llmName when set.
+// A citation is supported only when the overlap reaches minOverlap and the
+// model, if any, agrees; a contradiction found by the model always counts.
+// Checks journaled by an earlier session are reused. The Citation column of
+// every cited question records the outcome, any disagreement, and whether a
+// citation the model should have checked went unchecked.
+func (s *scheduler) verifyCitations(ctx context.Context, llmName string, minOverlap float64) {
+
+	var pending [][]string
+
+	for _, entry := range s.svc.journal.loadedCitation {
+		s.svc.ledger.add(phaseCitation, entry.Subject, entry.Topic, entry.LLMName, entry.Usage)
+	}
+
+	s.mu.Lock()
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		index := s.svc.sources[name]
+		if index == nil || llmName == "" {
+			continue
+		}
+
+		groups := make(map[string][]assessmentDataforMap)
+		for _, question := range slices.Sorted(maps.Keys(topic.Final)) {
+			v := topic.Final[question]
+			if _, ok := index.chunk(v.SourceChunk); !ok {
+				continue
+			}
+			if _, ok := s.svc.journal.citationDone(topic.Record[0], topic.Record[1], question); ok {
+				continue
+			}
+			groups[v.SourceChunk] = append(groups[v.SourceChunk], v)
+		}
+
+		for _, id := range slices.Sorted(maps.Keys(groups)) {
+			chunk, _ := index.chunk(id)
+			for items := range slices.Chunk(groups[id], judgeBatchSize) {
+				pending = append(pending, []string{getPromptForCitation(chunk, items), topic.Record[0], topic.Record[1],
+					items[0].Proficiency, items[0].Complexity, llmName})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if ctx.Err() == nil {
+		s.runReview(ctx, phaseCitation, newCitationModel, []string{llmName}, pending, func(r llmResponse) {
+			if r.Resp == nil {
+				return
+			}
+			logger := cellLogger(s.svc.logger, phaseCitation, llmName, r.Input[1], r.Input[2], r.Input[3], r.Input[4])
+			checked := getAllCitationResponseMap(logger, r.Resp)
+			if len(checked) == 0 {
+				return
+			}
+			err := s.svc.journal.record(journalEntry{Kind: journalKindCitation, Subject: r.Input[1], Topic: r.Input[2],
+				Proficiency: r.Input[3], Complexity: r.Input[4], Citations: slices.Collect(maps.Values(checked)),
+				LLMName: llmName, Usage: r.Stats})
+			if err != nil {
+				logger.Error("journal write failed", "err", err)
+			}
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summary citationSummary
+	sanitize := strings.NewReplacer(";", ",", "\n", " ")
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		index := s.svc.sources[name]
+		if index == nil {
+			continue
+		}
+
+		for question, v := range topic.Final {
+			chunk, ok := index.chunk(v.SourceChunk)
+			if !ok {
+				if v.Citation != "" {
+					summary.Fabricated++
+				}
+				continue
+			}
+
+			summary.Checked++
+			overlap := citationOverlap(chunk, v)
+			lexical := citationSupported
+			if overlap < minOverlap {
+				lexical = citationNotSupported
+			}
+			verdict, evidence, disagreement := lexical, "", ""
+			if check, ok := s.svc.journal.citationDone(topic.Record[0], topic.Record[1], question); ok {
+				evidence = check.Evidence
+				if check.Verdict != citationSupported {
+					verdict = check.Verdict
+				}
+				if (check.Verdict == citationSupported) != (lexical == citationSupported) {
+					disagreement = ", model says " + check.Verdict
+					summary.Disagreements++
+				}
+			} else if llmName != "" {
+				disagreement = ", model unchecked"
+				summary.Unchecked++
+			}
+
+			switch verdict {
+			case citationSupported:
+				summary.Supported++
+				v.Citation = fmt.Sprintf("supported, overlap %.2f", overlap)
+			case citationContradicted:
+				summary.Contradicted++
+				v.Citation = fmt.Sprintf("contradicted, overlap %.2f", overlap)
+			default:
+				summary.Unsupported++
+				v.Citation = fmt.Sprintf("unsupported, overlap %.2f", overlap)
+			}
+			v.Citation += disagreement
+			if evidence != "" && evidence != "None" {
+				v.Citation += ": " + sanitize.Replace(evidence)
+			}
+			topic.Final[question] = v
+		}
+
+		if err := csvWriteStringFile(topic.Final, topic.name()+"-"+"ValidatedAssessment.csv"); err != nil {
+			s.svc.logger.Error("validated assessment file not written", "subject", topic.Record[0], "topic", topic.Record[1], "err", err)
+		}
+	}
+
+	s.citations = summary
+
+	s.svc.logger.Info("citation verification done", "model", llmName, "checked", summary.Checked, "supported", summary.Supported,
+		"unsupported", summary.Unsupported, "contradicted", summary.Contradicted, "fabricated", summary.Fabricated,
+		"disagreements", summary.Disagreements, "unchecked", summary.Unchecked)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+const fakeGenerateContentResponse = `{
+  "candidates": [{
+    "content": {"role": "model", "parts": [{"text": "[]"}]},
+    "finishReason": "STOP",
+    "index": 0
+  }],
+  "usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 2, "totalTokenCount": 12}
+}`
+
+// fakeGenerateContent answers every request the way generateContent does,
+// with an empty assessment list.
+func fakeGenerateContent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(fakeGenerateContentResponse))
+}
+
+// fakeClientOptions point a client made by newLLMClient at a local fake
+// server, so that only the client overhead differs between the benchmarks.
+// The fake key comes last and overrides GEMINI_API_KEY.
+func fakeClientOptions(b *testing.B) []option.ClientOption {
+	server := httptest.NewServer(http.HandlerFunc(fakeGenerateContent))
+	b.Cleanup(server.Close)
+	return []option.ClientOption{option.WithEndpoint(server.URL), option.WithAPIKey("fake")}
+}
+
+// BenchmarkClientPerRequest makes every call through a client created for
+// that call, as the workers used to do.
+func BenchmarkClientPerRequest(b *testing.B) {
+
+	ctx := context.Background()
+	opts := fakeClientOptions(b)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client, err := newLLMClient(ctx, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := newValidationModel(client, validationLLMName).GenerateContent(ctx, genai.Text("Question")); err != nil {
+			b.Fatal(err)
+		}
+		client.Close()
+	}
+}
+
+// BenchmarkClientShared makes every call through one shared client.
+func BenchmarkClientShared(b *testing.B) {
+
+	ctx := context.Background()
+	opts := fakeClientOptions(b)
+
+	client, err := newLLMClient(ctx, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	model := newValidationModel(client, validationLLMName)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := model.GenerateContent(ctx, genai.Text("Question")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
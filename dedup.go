@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	shingleSize = 2
+	minhashSize = 64
+	// minhashRecall is the chance a pair right at the threshold shares a band
+	minhashRecall = 0.95
+)
+
+var dedupStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "in": true, "on": true, "to": true, "for": true, "and": true,
+	"or": true, "is": true, "are": true, "was": true, "be": true, "by": true, "with": true, "which": true,
+	"what": true, "following": true, "does": true, "do": true, "it": true, "its": true, "this": true, "that": true,
+}
+
+// bankQuestion is a question of the current run or of the historical bank,
+// prepared for comparison.
+type bankQuestion struct {
+	Assessment assessmentDataforMap
+	Source     string
+	topic      *topicState
+	shingles   map[uint64]empty
+	signature  []uint64
+}
+
+func normalizedTokens(text string) []string {
+
+	var tokens []string
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !dedupStopWords[token] {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
+
+func shingleSet(tokens []string) map[uint64]empty {
+
+	set := make(map[uint64]empty)
+	if len(tokens) < shingleSize {
+		for _, token := range tokens {
+			set[hashString(token)] = empty{}
+		}
+		return set
+	}
+
+	for idx := 0; idx+shingleSize <= len(tokens); idx++ {
+		set[hashString(strings.Join(tokens[idx:idx+shingleSize], " "))] = empty{}
+	}
+
+	return set
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix is splitmix64, used to derive the MinHash permutations.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// minhashBanding splits the signature into bands of rows for threshold:
+// the most rows per band, fewest false candidates, that still let a pair
+// whose similarity is threshold share a band with probability minhashRecall.
+func minhashBanding(threshold float64) (bands int, rows int) {
+
+	for rows = minhashSize; rows > 1; rows /= 2 {
+		bands = minhashSize / rows
+		if 1-math.Pow(1-math.Pow(threshold, float64(rows)), float64(bands)) >= minhashRecall {
+			return bands, rows
+		}
+	}
+
+	return minhashSize, 1
+}
+
+func minhashSignature(shingles map[uint64]empty) []uint64 {
+
+	signature := make([]uint64, minhashSize)
+	for idx := range signature {
+		signature[idx] = ^uint64(0)
+	}
+
+	for shingle := range shingles {
+		for idx := range signature {
+			if h := mix(shingle ^ uint64(idx+1)*0x9e3779b97f4a7c15); h < signature[idx] {
+				signature[idx] = h
+			}
+		}
+	}
+
+	return signature
+}
+
+func jaccard(a map[uint64]empty, b map[uint64]empty) float64 {
+
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for shingle := range a {
+		if _, ok := b[shingle]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func newBankQuestion(assessment assessmentDataforMap, source string, topic *topicState) *bankQuestion {
+	shingles := shingleSet(normalizedTokens(assessment.Question))
+	return &bankQuestion{Assessment: assessment, Source: source, topic: topic, shingles: shingles,
+		signature: minhashSignature(shingles)}
+}
+
+// validationRank orders questions by how well the validator backed them.
+func validationRank(v assessmentDataforMap) int {
+	switch validationVerdict(v) {
+	case verdictAgreed:
+		return 3
+	case verdictMismatched:
+		return 2
+	case verdictUnvalidated:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// loadQuestionBank reads earlier merged assessment files matching pattern,
+// skipping the files named in exclude.
+func loadQuestionBank(logger *slog.Logger, pattern string, exclude []string) []*bankQuestion {
+
+	fileNames, err := filepath.Glob(pattern)
+	if err != nil {
+		logger.Warn("question bank pattern invalid", "pattern", pattern, "err", err)
+		return nil
+	}
+
+	var bank []*bankQuestion
+	for _, fileName := range fileNames {
+		if slices.Contains(exclude, filepath.Clean(fileName)) {
+			continue
+		}
+
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			logger.Warn("skipping unreadable question bank file", "file", fileName, "err", err)
+			continue
+		}
+
+		lines := strings.Split(string(content), "\n")
+		header := strings.Split(strings.TrimSpace(lines[0]), ";")
+		column := func(fields []string, name string) string {
+			if idx := slices.Index(header, name); idx >= 0 && idx < len(fields) {
+				return fields[idx]
+			}
+			return ""
+		}
+		if !slices.Contains(header, "Question") {
+			logger.Warn("skipping question bank file without a header", "file", fileName)
+			continue
+		}
+
+		count := 0
+		for _, line := range lines[1:] {
+			fields := strings.Split(strings.TrimSpace(line), ";")
+			if len(fields) != len(header) {
+				continue
+			}
+			assessment := assessmentDataforMap{Subject: column(fields, "Subject"), Topic: column(fields, "Topic"),
+				Proficiency: column(fields, "Proficiency"), Complexity: column(fields, "Complexity"),
+				Question: column(fields, "Question"), Answer: column(fields, "Answer"),
+				ValidatedAnswer: column(fields, "ValidatedAnswer")}
+			bank = append(bank, newBankQuestion(assessment, fileName, nil))
+			count++
+		}
+
+		logger.Debug("question bank file loaded", "file", fileName, "questions", count)
+	}
+
+	return bank
+}
+
+// removeNearDuplicates clusters the questions of all finalized topics, and
+// those of the historical bank, whose shingle similarity reaches threshold.
+// Candidate pairs come from MinHash banding, with the bands sized for
+// threshold, and are confirmed on the exact Jaccard similarity. Each cluster
+// keeps its best validated question, preferring one already in the bank on a
+// tie; the others are dropped from their topics, whose validated assessment
+// files are rewritten.
+func (s *scheduler) removeNearDuplicates(threshold float64) int {
+
+	logger, bank := s.svc.logger, s.bank
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	questions := append([]*bankQuestion(nil), bank...)
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		for _, question := range slices.Sorted(maps.Keys(topic.Final)) {
+			questions = append(questions, newBankQuestion(topic.Final[question], "run", topic))
+		}
+	}
+
+	parent := make([]int, len(questions))
+	for idx := range parent {
+		parent[idx] = idx
+	}
+	var find func(int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+		return parent[idx]
+	}
+
+	bands, rows := minhashBanding(threshold)
+	buckets := make(map[uint64][]int)
+	for idx, question := range questions {
+		for band := 0; band < bands; band++ {
+			h := fnv.New64a()
+			binary.Write(h, binary.LittleEndian, uint64(band))
+			binary.Write(h, binary.LittleEndian, question.signature[band*rows:(band+1)*rows])
+			buckets[h.Sum64()] = append(buckets[h.Sum64()], idx)
+		}
+	}
+
+	compared := make(map[[2]int]bool)
+	for _, members := range buckets {
+		for i := 0; i < len(members); i++ {
+			for j := i + 1; j < len(members); j++ {
+				a, b := members[i], members[j]
+				if compared[[2]int{a, b}] || (questions[a].topic == nil && questions[b].topic == nil) {
+					continue
+				}
+				compared[[2]int{a, b}] = true
+				if jaccard(questions[a].shingles, questions[b].shingles) >= threshold {
+					parent[find(a)] = find(b)
+				}
+			}
+		}
+	}
+
+	clusters := make(map[int][]int)
+	for idx := range questions {
+		root := find(idx)
+		clusters[root] = append(clusters[root], idx)
+	}
+
+	removed := 0
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+
+		keeper := slices.MaxFunc(members, func(a, b int) int {
+			if c := validationRank(questions[a].Assessment) - validationRank(questions[b].Assessment); c != 0 {
+				return c
+			}
+			if questions[a].topic == nil && questions[b].topic != nil {
+				return 1
+			}
+			if questions[a].topic != nil && questions[b].topic == nil {
+				return -1
+			}
+			return b - a
+		})
+
+		var dropped []string
+		for _, idx := range members {
+			question := questions[idx]
+			if idx == keeper || question.topic == nil {
+				continue
+			}
+			delete(question.topic.Final, question.Assessment.Question)
+			question.topic.NearDuplicates++
+			dropped = append(dropped, question.Assessment.Question)
+			removed++
+		}
+
+		logger.Info("near-duplicate cluster", "kept", questions[keeper].Assessment.Question,
+			"kept_from", questions[keeper].Source, "verdict", validationVerdict(questions[keeper].Assessment),
+			"size", len(members), "dropped", dropped)
+	}
+
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		if topic.NearDuplicates > 0 {
+			if err := csvWriteStringFile(topic.Final, topic.name()+"-"+"ValidatedAssessment.csv"); err != nil {
+				logger.Error("validated assessment file not written", "subject", topic.Record[0], "topic", topic.Record[1], "err", err)
+			}
+		}
+	}
+
+	logger.Info("near-duplicate detection done", "questions", len(questions), "bank", len(bank), "removed", removed,
+		"bands", bands, "rows", rows)
+
+	return removed
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	sourceChunkWords = 180
+	bm25K1           = 1.2
+	bm25B            = 0.75
+	// sourcePoolCells is how many cells' worth of the best passages the
+	// cells of a topic rotate through
+	sourcePoolCells = 2
+)
+
+var (
+	htmlDropPattern    = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlHeadingPattern = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]>`)
+	htmlTagPattern     = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// sourceChunk is a passage of a reference document small enough to quote in a
+// generation prompt.
+type sourceChunk struct {
+	ID        string
+	Reference string
+	Text      string
+	terms     map[string]int
+	length    int
+	score     float64
+}
+
+// sourceSection is a titled part of a document before it is chunked.
+type sourceSection struct {
+	Title string
+	Text  string
+}
+
+// sourceIndex holds the chunks of the reference material of one topic, in
+// BM25 order against the topic.
+type sourceIndex struct {
+	chunks []sourceChunk
+}
+
+// markdownSections splits a document at its headings.
+func markdownSections(content string) []sourceSection {
+
+	var sections []sourceSection
+	current := sourceSection{}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			sections = append(sections, current)
+			current = sourceSection{Title: strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))}
+			continue
+		}
+		current.Text += line + "\n"
+	}
+
+	return append(sections, current)
+}
+
+// documentSections reads a reference document by its extension. HTML headings
+// become section titles; the pages of text extracted from a PDF, separated by
+// form feeds, become sections too.
+func documentSections(fileName string, content string) ([]sourceSection, error) {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".md", ".markdown":
+		return markdownSections(content), nil
+	case ".html", ".htm":
+		content = htmlDropPattern.ReplaceAllString(content, " ")
+		content = htmlHeadingPattern.ReplaceAllString(content, "\n# $1\n")
+		content = html.UnescapeString(htmlTagPattern.ReplaceAllString(content, " "))
+		return markdownSections(content), nil
+	case ".pdf":
+		return nil, fmt.Errorf("extract the text of %s first, e.g. with pdftotext", fileName)
+	default:
+		pages := strings.Split(content, "\f")
+		if len(pages) == 1 {
+			return []sourceSection{{Text: content}}, nil
+		}
+		var sections []sourceSection
+		for idx, page := range pages {
+			sections = append(sections, sourceSection{Title: "page " + strconv.Itoa(idx+1), Text: page})
+		}
+		return sections, nil
+	}
+}
+
+// chunkSections cuts every section into chunks of sourceChunkWords words,
+// each referenced as file#section.
+func chunkSections(fileName string, sections []sourceSection) []sourceChunk {
+
+	var chunks []sourceChunk
+	for _, section := range sections {
+		words := strings.Fields(section.Text)
+		reference := fileName
+		if section.Title != "" {
+			reference += "#" + section.Title
+		}
+
+		parts := slices.Collect(slices.Chunk(words, sourceChunkWords))
+		for idx, part := range parts {
+			chunk := sourceChunk{Reference: reference, Text: strings.Join(part, " "), terms: make(map[string]int)}
+			if len(parts) > 1 {
+				chunk.Reference += " (part " + strconv.Itoa(idx+1) + ")"
+			}
+			for _, token := range normalizedTokens(chunk.Text) {
+				chunk.terms[token]++
+				chunk.length++
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	return chunks
+}
+
+// newSourceIndex ranks the chunks with BM25 against the query and numbers
+// them in that order.
+func newSourceIndex(chunks []sourceChunk, query string) *sourceIndex {
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	documentFrequency := make(map[string]int)
+	totalLength := 0
+	for _, chunk := range chunks {
+		for term := range chunk.terms {
+			documentFrequency[term]++
+		}
+		totalLength += chunk.length
+	}
+	averageLength := float64(totalLength) / float64(len(chunks))
+
+	terms := normalizedTokens(query)
+	scores := make([]float64, len(chunks))
+	for idx, chunk := range chunks {
+		for _, term := range terms {
+			frequency := float64(chunk.terms[term])
+			if frequency == 0 {
+				continue
+			}
+			df := float64(documentFrequency[term])
+			idf := math.Log(1 + (float64(len(chunks))-df+0.5)/(df+0.5))
+			scores[idx] += idf * frequency * (bm25K1 + 1) /
+				(frequency + bm25K1*(1-bm25B+bm25B*float64(chunk.length)/averageLength))
+		}
+	}
+
+	order := make([]int, len(chunks))
+	for idx := range order {
+		order[idx] = idx
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case scores[a] > scores[b]:
+			return -1
+		case scores[a] < scores[b]:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	index := &sourceIndex{}
+	for rank, idx := range order {
+		chunk := chunks[idx]
+		chunk.ID = "S" + strconv.Itoa(rank+1)
+		chunk.score = scores[idx]
+		index.chunks = append(index.chunks, chunk)
+	}
+
+	return index
+}
+
+// loadSourceIndexes reads the reference material of every topic from a CSV
+// file with the rows Subject,Topic,Glob and indexes it per Subject-Topic,
+// where Topic may name a sub-topic as "Topic - SubTopic".
+func loadSourceIndexes(logger *slog.Logger, fileName string) (map[string]*sourceIndex, error) {
+
+	csvfile, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer csvfile.Close()
+
+	record, err := csv.NewReader(csvfile).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("sources file %s: %w", fileName, err)
+	}
+
+	chunks := make(map[string][]sourceChunk)
+	for idx, row := range record {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("sources file %s line %d: expected Subject,Topic,Glob", fileName, idx+1)
+		}
+		pattern := strings.TrimSpace(row[2])
+		documents, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("sources file %s line %d: %w", fileName, idx+1, err)
+		}
+		if len(documents) == 0 {
+			logger.Warn("no reference documents found", "subject", row[0], "topic", row[1], "pattern", pattern)
+		}
+
+		key := strings.TrimSpace(row[0]) + "-" + strings.TrimSpace(row[1])
+		for _, document := range documents {
+			content, err := os.ReadFile(document)
+			if err != nil {
+				logger.Warn("skipping unreadable reference document", "file", document, "err", err)
+				continue
+			}
+			sections, err := documentSections(document, string(content))
+			if err != nil {
+				logger.Warn("skipping reference document", "file", document, "err", err)
+				continue
+			}
+			chunks[key] = append(chunks[key], chunkSections(document, sections)...)
+		}
+	}
+
+	indexes := make(map[string]*sourceIndex)
+	for key, topicChunks := range chunks {
+		indexes[key] = newSourceIndex(topicChunks, strings.ReplaceAll(key, "-", " "))
+		logger.Info("reference material indexed", "topic", key, "chunks", len(topicChunks))
+		if len(indexes[key].forCell(profList[0], complexityList[0], 1)) == 0 {
+			logger.Warn("no reference passage mentions the topic, none will be quoted", "topic", key)
+		}
+	}
+
+	return indexes, nil
+}
+
+// forCell picks the chunks quoted in the prompt of a generation cell. Only
+// chunks that matched the topic are quoted: the cells rotate through the best
+// sourcePoolCells*k of them, so that no cell is left with the least relevant
+// passages and neighbouring cells do not all quote the same.
+func (x *sourceIndex) forCell(proficiency string, complexity string, k int) []sourceChunk {
+
+	if x == nil || k <= 0 {
+		return nil
+	}
+
+	pool := 0
+	for pool < min(sourcePoolCells*k, len(x.chunks)) && x.chunks[pool].score > 0 {
+		pool++
+	}
+	if pool <= k {
+		return slices.Clone(x.chunks[:pool])
+	}
+
+	cell := max(slices.Index(profList, proficiency), 0)*len(complexityList) + max(slices.Index(complexityList, complexity), 0)
+
+	var chunks []sourceChunk
+	for idx := 0; idx < k; idx++ {
+		chunks = append(chunks, x.chunks[(cell*k+idx)%pool])
+	}
+
+	return chunks
+}
+
+// withSources appends the reference passages to a generation prompt and asks
+// each question to cite the one it comes from.
+func withSources(prompt string, chunks []sourceChunk) string {
+
+	if len(chunks) == 0 {
+		return prompt
+	}
+
+	var passages string
+	for _, chunk := range chunks {
+		passages += fmt.Sprintf(`
+	$$$
+	%s (%s):
+	%s
+	$$$`, chunk.ID, chunk.Reference, chunk.Text)
+	}
+
+	return prompt + `
+
+	Articulate every Question from the following reference passages only, each delimited by $$$ and starting with its ID
+	` + passages + `
+	For each Question generated, add the ID of the passage it was articulated from to the Assessment as 'SourceChunk': string`
+}
+
+// resolveSources replaces the Source each question claims with the reference
+// of the chunk it cites. It returns how many questions cite no chunk of the
+// prompt; their Source becomes None and their Citation says what was cited.
+func resolveSources(assessments map[string]assessmentDataforMap, chunks []sourceChunk) int {
+
+	if len(chunks) == 0 {
+		return 0
+	}
+
+	uncited := 0
+	for question, v := range assessments {
+		v.SourceChunk = strings.TrimSpace(v.SourceChunk)
+		idx := slices.IndexFunc(chunks, func(c sourceChunk) bool { return c.ID == v.SourceChunk })
+		if idx >= 0 {
+			v.Source = chunks[idx].Reference
+		} else {
+			v.Citation = "no citation"
+			if v.SourceChunk != "" {
+				v.Citation = "fabricated citation " + strings.NewReplacer(";", ",", "\n", " ").Replace(v.SourceChunk)
+			}
+			v.Source = "None"
+			v.SourceChunk = ""
+			uncited++
+		}
+		assessments[question] = v
+	}
+
+	return uncited
+}
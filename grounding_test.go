@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testSourceIndex(relevant int, irrelevant int) *sourceIndex {
+
+	var sections []sourceSection
+	for idx := 0; idx < relevant; idx++ {
+		sections = append(sections, sourceSection{Title: fmt.Sprint("r", idx), Text: "attention weighs the tokens of transformers"})
+	}
+	for idx := 0; idx < irrelevant; idx++ {
+		sections = append(sections, sourceSection{Title: fmt.Sprint("i", idx), Text: "gradient boosting grows shallow trees"})
+	}
+
+	return newSourceIndex(chunkSections("doc.md", sections), "GenAI Transformers")
+}
+
+func TestForCell(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		relevant   int
+		irrelevant int
+		k          int
+		want       int
+	}{
+		{name: "nothing relevant", relevant: 0, irrelevant: 10, k: 4, want: 0},
+		{name: "fewer relevant than k", relevant: 2, irrelevant: 30, k: 4, want: 2},
+		{name: "more relevant than k", relevant: 6, irrelevant: 30, k: 4, want: 4},
+		{name: "many relevant", relevant: 40, irrelevant: 0, k: 4, want: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := testSourceIndex(test.relevant, test.irrelevant)
+			for _, proficiency := range profList {
+				for _, complexity := range complexityList {
+					chunks := index.forCell(proficiency, complexity, test.k)
+					if len(chunks) != test.want {
+						t.Errorf("forCell(%s, %s) = %d chunks, want %d", proficiency, complexity, len(chunks), test.want)
+					}
+					for _, chunk := range chunks {
+						if chunk.score <= 0 {
+							t.Errorf("forCell(%s, %s) quotes %s, which did not match the topic", proficiency, complexity, chunk.ID)
+						}
+						if rank := chunkRank(chunk.ID); rank > sourcePoolCells*test.k {
+							t.Errorf("forCell(%s, %s) quotes %s, outside the best %d", proficiency, complexity, chunk.ID, sourcePoolCells*test.k)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func chunkRank(id string) int {
+	var rank int
+	fmt.Sscanf(id, "S%d", &rank)
+	return rank
+}
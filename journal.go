@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalKindGeneration  = "generation"
+	journalKindValidation  = "validation"
+	journalKindCalibration = "calibration"
+	journalKindJudge       = "judge"
+	journalKindCitation    = "citation"
+)
+
+// journalEntry is one completed unit of work. Generation entries carry the
+// parsed assessments and the validation prompt built from them, validation
+// entries the validated answers, so a resumed run can rebuild its maps
+// without calling the model again. Usage lets the cost report of a resumed
+// run include what earlier sessions spent.
+type journalEntry struct {
+	Kind                string
+	Subject             string
+	Topic               string
+	Proficiency         string
+	Complexity          string
+	Assessments         []assessmentDataforMap    `json:",omitempty"`
+	PromptforValidation string                    `json:",omitempty"`
+	Validated           []assessmentValidatedData `json:",omitempty"`
+	Safety              []responseSafety          `json:",omitempty"`
+	Judged              []judgeScores             `json:",omitempty"`
+	Revisions           []revisionChange          `json:",omitempty"`
+	Citations           []citationCheck           `json:",omitempty"`
+	LLMName             string
+	Usage               callStats
+	RevisionUsage       callStats
+	CompletedAt         time.Time
+}
+
+func (e journalEntry) key() string {
+	return cellKey(e.Subject, e.Topic, e.Proficiency, e.Complexity)
+}
+
+func cellKey(subject string, topic string, proficiency string, complexity string) string {
+	return subject + "|" + topic + "|" + proficiency + "|" + complexity
+}
+
+// runJournal is an append-only JSON lines file under the run directory.
+type runJournal struct {
+	mu          sync.Mutex
+	file        *os.File
+	generation  map[string]journalEntry
+	validation  map[string]journalEntry
+	calibration map[string]journalEntry
+	judged      map[string]judgeScores
+	// loadedJudge keeps the judge entries of earlier sessions for their usage
+	loadedJudge []journalEntry
+	cited       map[string]citationCheck
+	// loadedCitation does the same for the citation checks
+	loadedCitation []journalEntry
+}
+
+func runDirectory(runID string) string {
+	return filepath.Join("runs", runID)
+}
+
+func newRunID() string {
+	return time.Now().Format("20060102-150405")
+}
+
+// openRunJournal loads the journal of runID, if any, and opens it for
+// appending. A torn last line left by a crash is cut off.
+func openRunJournal(runID string) (*runJournal, error) {
+
+	dir := runDirectory(runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	fileName := filepath.Join(dir, "journal.jsonl")
+
+	journal := &runJournal{
+		generation:  make(map[string]journalEntry),
+		validation:  make(map[string]journalEntry),
+		calibration: make(map[string]journalEntry),
+		judged:      make(map[string]judgeScores),
+		cited:       make(map[string]citationCheck),
+	}
+
+	// complete is the length of the journal up to its last full line
+	var complete int64
+	torn := false
+	if existing, err := os.Open(fileName); err == nil {
+		reader := bufio.NewReader(existing)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				torn = len(line) > 0
+				break
+			}
+			if err != nil {
+				existing.Close()
+				return nil, err
+			}
+			complete += int64(len(line))
+
+			var entry journalEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				slog.Warn("skipping unreadable journal line", "file", fileName, "err", err)
+				continue
+			}
+			switch entry.Kind {
+			case journalKindGeneration:
+				journal.generation[entry.key()] = entry
+			case journalKindValidation:
+				journal.validation[entry.key()] = entry
+			case journalKindCalibration:
+				journal.calibration[entry.key()+"|"+entry.LLMName] = entry
+			case journalKindJudge:
+				for _, scores := range entry.Judged {
+					journal.judged[entry.Subject+"|"+entry.Topic+"|"+scores.Question] = scores
+				}
+				journal.loadedJudge = append(journal.loadedJudge, entry)
+			case journalKindCitation:
+				for _, check := range entry.Citations {
+					journal.cited[entry.Subject+"|"+entry.Topic+"|"+check.Question] = check
+				}
+				journal.loadedCitation = append(journal.loadedCitation, entry)
+			}
+		}
+		existing.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// Cut the torn line off so the next entry starts on a line of its own
+	if torn {
+		slog.Warn("dropping torn journal line", "file", fileName, "offset", complete)
+		if err := file.Truncate(complete); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	journal.file = file
+
+	return journal, nil
+}
+
+func (j *runJournal) generationDone(key string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.generation[key]
+	return entry, ok
+}
+
+func (j *runJournal) validationDone(key string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.validation[key]
+	return entry, ok
+}
+
+func (j *runJournal) calibrationDone(key string, llmName string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.calibration[key+"|"+llmName]
+	return entry, ok
+}
+
+func (j *runJournal) judgeDone(subject string, topic string, question string) (judgeScores, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	scores, ok := j.judged[subject+"|"+topic+"|"+question]
+	return scores, ok
+}
+
+func (j *runJournal) citationDone(subject string, topic string, question string) (citationCheck, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	check, ok := j.cited[subject+"|"+topic+"|"+question]
+	return check, ok
+}
+
+func (j *runJournal) record(entry journalEntry) error {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.CompletedAt = time.Now()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	switch entry.Kind {
+	case journalKindGeneration:
+		j.generation[entry.key()] = entry
+	case journalKindValidation:
+		j.validation[entry.key()] = entry
+	case journalKindCalibration:
+		j.calibration[entry.key()+"|"+entry.LLMName] = entry
+	case journalKindJudge:
+		for _, scores := range entry.Judged {
+			j.judged[entry.Subject+"|"+entry.Topic+"|"+scores.Question] = scores
+		}
+	case journalKindCitation:
+		for _, check := range entry.Citations {
+			j.cited[entry.Subject+"|"+entry.Topic+"|"+check.Question] = check
+		}
+	}
+
+	return nil
+}
+
+func (j *runJournal) Close() error {
+	return j.file.Close()
+}
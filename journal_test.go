@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenRunJournal(t *testing.T) {
+
+	first := `{"Kind":"generation","Subject":"GenAI","Topic":"Transformers","Proficiency":"Learner","Complexity":"Easy"}` + "\n"
+	second := `{"Kind":"validation","Subject":"GenAI","Topic":"Transformers","Proficiency":"Learner","Complexity":"Easy"}` + "\n"
+
+	tests := []struct {
+		name       string
+		content    string
+		generation int
+		validation int
+		kept       string
+	}{
+		{name: "new", content: "", kept: ""},
+		{name: "complete", content: first + second, generation: 1, validation: 1, kept: first + second},
+		{name: "torn last line", content: first + `{"Kind":"validation","Sub`, generation: 1, kept: first},
+		{name: "unreadable line", content: "not json\n" + first, generation: 1, kept: "not json\n" + first},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runID := strings.ReplaceAll(test.name, " ", "-")
+			fileName := filepath.Join(runDirectory(runID), "journal.jsonl")
+			if test.content != "" {
+				if err := os.MkdirAll(runDirectory(runID), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(fileName, []byte(test.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			journal, err := openRunJournal(runID)
+			if err != nil {
+				t.Fatalf("openRunJournal() error = %v", err)
+			}
+			if len(journal.generation) != test.generation || len(journal.validation) != test.validation {
+				t.Errorf("openRunJournal() replayed %d generation and %d validation entries, want %d and %d",
+					len(journal.generation), len(journal.validation), test.generation, test.validation)
+			}
+
+			// the next entry must start on a line of its own
+			if err := journal.record(journalEntry{Kind: journalKindJudge, Subject: "GenAI", Topic: "Transformers"}); err != nil {
+				t.Fatal(err)
+			}
+			journal.Close()
+
+			content, err := os.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(content), test.kept) {
+				t.Fatalf("journal = %q, want it to start with %q", content, test.kept)
+			}
+			if appended := strings.TrimPrefix(string(content), test.kept); !strings.HasPrefix(appended, `{"Kind":"judge"`) ||
+				strings.Count(appended, "\n") != 1 {
+				t.Errorf("journal appended %q, want one judge line", appended)
+			}
+		})
+	}
+}
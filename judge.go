@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const phaseJudge = "judge"
+
+// judgeBatchSize is how many questions go into one judge prompt.
+const judgeBatchSize = 9
+
+var systemPromptForJudge = `You are an expert assessment reviewer in AI literature. You will be given multiple choice questions
+written to assess a Talent of a given Proficiency, either a Learner, Practitioner or Specialist, on a Topic of a Subject.
+Rate each question from 1 (poor) to 5 (excellent) on each of the following dimensions using this rubric
+1) Clarity                : 5 when the question is unambiguous and has a single defensible answer, 1 when it is confusing or has several answers.
+2) Relevance              : 5 when the question tests a central idea of the Topic, 1 when it is off Topic.
+3) ProficiencyFit         : 5 when the question suits the stated Proficiency, 1 when it is far too easy or far too hard for it.
+4) DistractorPlausibility : 5 when every wrong option is plausible to someone who lacks the knowledge, 1 when the wrong options are obviously wrong.
+5) ReasoningQuality       : 5 when the Reasoning correctly and fully explains the Answer, 1 when it is wrong or missing.
+Judge only what is given, do not answer the questions yourself.`
+
+// judgeScores is the judge's rating of one question.
+type judgeScores struct {
+	Question               string
+	Clarity                int
+	Relevance              int
+	ProficiencyFit         int
+	DistractorPlausibility int
+	ReasoningQuality       int
+	Comment                string
+}
+
+// mean averages the five dimensions, or returns 0 when any is out of range.
+func (j judgeScores) mean() float64 {
+
+	scores := []int{j.Clarity, j.Relevance, j.ProficiencyFit, j.DistractorPlausibility, j.ReasoningQuality}
+
+	total := 0
+	for _, score := range scores {
+		if score < 1 || score > 5 {
+			return 0
+		}
+		total += score
+	}
+
+	return float64(total) / float64(len(scores))
+}
+
+func newJudgeModel(client *genai.Client, llmName string) *genai.GenerativeModel {
+
+	model := client.GenerativeModel(llmName)
+	model.ResponseMIMEType = "application/json"
+	const ChatTemperature float32 = 0.0
+	temperature := ChatTemperature
+	model.Temperature = &temperature
+
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(systemPromptForJudge)},
+	}
+
+	return model
+}
+
+func getPromptForJudge(subject string, topic string, allQuizes []assessmentDataforMap) string {
+
+	var stepsSequencePrompt string
+
+	stepsPrompt := `
+	$$$
+	Question %d for a %s:
+	%s
+	Options: %s
+	Answer: %s
+	Reasoning: %s
+	$$$`
+
+	for idx, quiz := range allQuizes {
+		stepsSequencePrompt += fmt.Sprintf(stepsPrompt, idx, quiz.Proficiency, quiz.Question, strings.Join(quiz.AllOptions, " | "),
+			quiz.Answer, quiz.Reasoning)
+	}
+
+	return fmt.Sprintf(`
+	The following are Questions on the Topic of %s within the Subject of %s, each delimited by $$$
+	%s
+
+	Return the results using this JSON schema:
+	Rating = {
+	'Question' : string
+	'Clarity': integer
+	'Relevance': integer
+	'ProficiencyFit': integer
+	'DistractorPlausibility': integer
+	'ReasoningQuality': integer
+	'Comment': string
+	}
+	Return: Array<Rating>`, topic, subject, stepsSequencePrompt)
+}
+
+func getAllJudgedResponseMap(logger *slog.Logger, resp *genai.GenerateContentResponse) map[string]judgeScores {
+
+	judgedMap := make(map[string]judgeScores)
+
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			txt, ok := part.(genai.Text)
+			if !ok {
+				continue
+			}
+			var dataString []judgeScores
+			if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
+				logger.Warn("unparseable judge response", "err", err)
+				continue
+			}
+			for _, scores := range dataString {
+				if scores.mean() > 0 {
+					judgedMap[scores.Question] = scores
+				}
+			}
+		}
+	}
+
+	return judgedMap
+}
+
+// judgeColumns formats the judge scores for the assessment files, leaving
+// them empty for questions that were not judged.
+func judgeColumns(v assessmentDataforMap, sep string) string {
+
+	if v.JudgeScore == 0 {
+		return strings.Repeat(sep, 6)
+	}
+
+	return fmt.Sprint(v.JudgeClarity) + sep + fmt.Sprint(v.JudgeRelevance) + sep + fmt.Sprint(v.JudgeProficiencyFit) + sep +
+		fmt.Sprint(v.JudgeDistractors) + sep + fmt.Sprint(v.JudgeReasoning) + sep + fmt.Sprintf("%.1f", v.JudgeScore) + sep +
+		strings.NewReplacer(sep, ",", "\n", " ").Replace(v.JudgeComment)
+}
+
+func (v *assessmentDataforMap) setJudgeScores(scores judgeScores) {
+	v.JudgeClarity = scores.Clarity
+	v.JudgeRelevance = scores.Relevance
+	v.JudgeProficiencyFit = scores.ProficiencyFit
+	v.JudgeDistractors = scores.DistractorPlausibility
+	v.JudgeReasoning = scores.ReasoningQuality
+	v.JudgeScore = scores.mean()
+	v.JudgeComment = scores.Comment
+}
+
+// judge has llmName rate every question kept in the topics, reusing the
+// ratings journaled by an earlier session, and rewrites the validated
+// assessment files with the scores.
+func (s *scheduler) judge(ctx context.Context, llmName string) {
+
+	var pending [][]string
+
+	for _, entry := range s.svc.journal.loadedJudge {
+		s.svc.ledger.add(phaseJudge, entry.Subject, entry.Topic, entry.LLMName, entry.Usage)
+	}
+
+	s.mu.Lock()
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+
+		groups := make(map[string][]assessmentDataforMap)
+		for _, question := range slices.Sorted(maps.Keys(topic.Final)) {
+			v := topic.Final[question]
+			if scores, ok := s.svc.journal.judgeDone(topic.Record[0], topic.Record[1], question); ok {
+				v.setJudgeScores(scores)
+				topic.Final[question] = v
+				continue
+			}
+			groups[v.Proficiency+"|"+v.Complexity] = append(groups[v.Proficiency+"|"+v.Complexity], v)
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(groups)) {
+			proficiency, complexity, _ := strings.Cut(key, "|")
+			for chunk := range slices.Chunk(groups[key], judgeBatchSize) {
+				pending = append(pending, []string{getPromptForJudge(topic.Record[0], topic.Record[1], chunk),
+					topic.Record[0], topic.Record[1], proficiency, complexity, llmName})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if ctx.Err() == nil {
+		s.runReview(ctx, phaseJudge, newJudgeModel, []string{llmName}, pending, func(r llmResponse) {
+			if r.Resp == nil {
+				return
+			}
+			logger := cellLogger(s.svc.logger, phaseJudge, llmName, r.Input[1], r.Input[2], r.Input[3], r.Input[4])
+			judged := getAllJudgedResponseMap(logger, r.Resp)
+			if len(judged) == 0 {
+				return
+			}
+			err := s.svc.journal.record(journalEntry{Kind: journalKindJudge, Subject: r.Input[1], Topic: r.Input[2],
+				Proficiency: r.Input[3], Complexity: r.Input[4], Judged: slices.Collect(maps.Values(judged)),
+				LLMName: llmName, Usage: r.Stats})
+			if err != nil {
+				logger.Error("journal write failed", "err", err)
+			}
+
+			s.mu.Lock()
+			topic := s.topics[r.Input[1]+"-"+r.Input[2]]
+			for question, scores := range judged {
+				if v, ok := topic.Final[question]; ok {
+					v.setJudgeScores(scores)
+					topic.Final[question] = v
+				}
+			}
+			s.mu.Unlock()
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	judged := 0
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		for _, v := range topic.Final {
+			if v.JudgeScore > 0 {
+				judged++
+			}
+		}
+		if err := csvWriteStringFile(topic.Final, topic.name()+"-"+"ValidatedAssessment.csv"); err != nil {
+			s.svc.logger.Error("validated assessment file not written", "subject", topic.Record[0], "topic", topic.Record[1], "err", err)
+		}
+	}
+
+	s.svc.logger.Info("judging done", "model", llmName, "judged", judged)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const maxOptionWords = 5
+
+type lintSeverity int
+
+const (
+	lintOff lintSeverity = iota
+	lintInfo
+	lintWarn
+	lintError
+)
+
+var lintSeverityNames = map[string]lintSeverity{"off": lintOff, "info": lintInfo, "warn": lintWarn, "error": lintError}
+
+func (s lintSeverity) String() string {
+	switch s {
+	case lintInfo:
+		return "info"
+	case lintWarn:
+		return "warn"
+	case lintError:
+		return "error"
+	default:
+		return "off"
+	}
+}
+
+// Lint rules checked on every generated question.
+const (
+	lintOptionCount       = "option-count"
+	lintAnswerNotAnOption = "answer-not-an-option"
+	lintOptionLength      = "option-length"
+	lintDuplicateOptions  = "duplicate-options"
+	lintAboveOptions      = "all-none-of-the-above"
+	lintLongestAnswer     = "longest-answer"
+	lintAnswerInStem      = "answer-in-stem"
+	lintNegativeStem      = "negative-stem"
+)
+
+// defaultLintRules reject what cannot be written or validated and annotate
+// the rest.
+var defaultLintRules = map[string]lintSeverity{
+	lintOptionCount:       lintError,
+	lintAnswerNotAnOption: lintError,
+	lintDuplicateOptions:  lintError,
+	lintOptionLength:      lintWarn,
+	lintAboveOptions:      lintWarn,
+	lintLongestAnswer:     lintWarn,
+	lintAnswerInStem:      lintWarn,
+	lintNegativeStem:      lintInfo,
+}
+
+// parseLintRules reads severities in the form
+// "option-length=error,negative-stem=off".
+func parseLintRules(value string, rules map[string]lintSeverity) error {
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rule, severityName, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("lint rule %q: expected rule=severity", entry)
+		}
+		rule = strings.TrimSpace(rule)
+		if _, ok := defaultLintRules[rule]; !ok {
+			return fmt.Errorf("lint rule %q: unknown rule, expected one of %s", entry,
+				strings.Join(slices.Sorted(maps.Keys(defaultLintRules)), ", "))
+		}
+		severity, ok := lintSeverityNames[strings.ToLower(strings.TrimSpace(severityName))]
+		if !ok {
+			return fmt.Errorf("lint rule %q: expected off, info, warn or error", entry)
+		}
+
+		rules[rule] = severity
+	}
+
+	return nil
+}
+
+type lintFinding struct {
+	Rule     string
+	Severity lintSeverity
+	Detail   string
+}
+
+func (f lintFinding) String() string {
+	return f.Severity.String() + ":" + f.Rule + "(" + f.Detail + ")"
+}
+
+func normalizedOption(option string) string {
+	return strings.Join(normalizedTokens(option), " ")
+}
+
+// lintAssessment checks one question against the enabled rules.
+func lintAssessment(v assessmentDataforMap, rules map[string]lintSeverity) []lintFinding {
+
+	var findings []lintFinding
+	report := func(rule string, detail string) {
+		if severity := rules[rule]; severity != lintOff {
+			findings = append(findings, lintFinding{Rule: rule, Severity: severity, Detail: detail})
+		}
+	}
+
+	if len(v.AllOptions) != 4 {
+		report(lintOptionCount, fmt.Sprintf("%d options", len(v.AllOptions)))
+	}
+
+	answerIndex := slices.IndexFunc(v.AllOptions, func(option string) bool {
+		return strings.TrimSpace(option) == strings.TrimSpace(v.Answer)
+	})
+	if answerIndex < 0 {
+		report(lintAnswerNotAnOption, v.Answer)
+	}
+
+	seen := make(map[string]string)
+	for _, option := range v.AllOptions {
+		if words := len(strings.Fields(option)); words > maxOptionWords {
+			report(lintOptionLength, fmt.Sprintf("%q has %d words", option, words))
+		}
+
+		normalized := normalizedOption(option)
+		for other, otherOption := range seen {
+			if normalized == other || jaccard(shingleSet(strings.Fields(normalized)), shingleSet(strings.Fields(other))) >= 0.8 {
+				report(lintDuplicateOptions, fmt.Sprintf("%q and %q", otherOption, option))
+			}
+		}
+		seen[normalized] = option
+
+		lower := strings.ToLower(option)
+		if strings.Contains(lower, "all of the above") || strings.Contains(lower, "none of the above") {
+			report(lintAboveOptions, option)
+		}
+	}
+
+	if answerIndex >= 0 && len(v.AllOptions) > 1 {
+		distractorLength := 0
+		longest := true
+		for idx, option := range v.AllOptions {
+			if idx == answerIndex {
+				continue
+			}
+			distractorLength += len(option)
+			if len(option) >= len(v.Answer) {
+				longest = false
+			}
+		}
+		average := float64(distractorLength) / float64(len(v.AllOptions)-1)
+		if longest && float64(len(v.Answer)) > 1.5*average {
+			report(lintLongestAnswer, fmt.Sprintf("%d characters against %.0f", len(v.Answer), average))
+		}
+	}
+
+	stem := normalizedTokens(v.Question)
+	for _, word := range normalizedTokens(v.Answer) {
+		if len(word) > 3 && slices.Contains(stem, word) {
+			report(lintAnswerInStem, word)
+		}
+	}
+
+	for _, word := range []string{"not", "except", "never", "false", "incorrect"} {
+		if slices.Contains(stem, word) {
+			report(lintNegativeStem, word)
+			break
+		}
+	}
+
+	return findings
+}
+
+// lintBatch sets the lint column of every question and splits off those with
+// error findings, which are not validated.
+func lintBatch(assessments map[string]assessmentDataforMap, rules map[string]lintSeverity) (map[string]assessmentDataforMap, map[string]assessmentDataforMap) {
+
+	accepted := make(map[string]assessmentDataforMap)
+	rejected := make(map[string]assessmentDataforMap)
+
+	for question, assessment := range assessments {
+		findings := lintAssessment(assessment, rules)
+
+		var notes []string
+		failed := false
+		for _, finding := range findings {
+			notes = append(notes, finding.String())
+			failed = failed || finding.Severity == lintError
+		}
+		assessment.Lint = strings.Join(notes, " ")
+
+		if failed {
+			rejected[question] = assessment
+		} else {
+			accepted[question] = assessment
+		}
+	}
+
+	return accepted, rejected
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestLintAssessment(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		v     assessmentDataforMap
+		rules map[string]lintSeverity
+		want  []string
+	}{
+		{
+			name: "clean",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "Softmax", "Dropout"}},
+			want: nil,
+		},
+		{
+			name: "three options",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "Softmax"}},
+			want: []string{lintOptionCount},
+		},
+		{
+			name: "answer not an option",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Convolution",
+				AllOptions: []string{"Attention", "Embedding", "Softmax", "Dropout"}},
+			want: []string{lintAnswerNotAnOption},
+		},
+		{
+			name: "duplicate options",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "the embedding", "Dropout"}},
+			want: []string{lintDuplicateOptions},
+		},
+		{
+			name: "long option and all of the above",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "Softmax", "All of the above options are correct here"}},
+			want: []string{lintOptionLength, lintAboveOptions},
+		},
+		{
+			name: "longest answer",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Multi-head self attention",
+				AllOptions: []string{"Multi-head self attention", "Embedding", "Softmax", "Dropout"}},
+			want: []string{lintLongestAnswer},
+		},
+		{
+			name: "answer in stem and negative stem",
+			v: assessmentDataforMap{Question: "Which is not part of attention?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "Softmax", "Dropout"}},
+			want: []string{lintAnswerInStem, lintNegativeStem},
+		},
+		{
+			name: "rule turned off",
+			v: assessmentDataforMap{Question: "Which layer mixes tokens in a transformer?", Answer: "Attention",
+				AllOptions: []string{"Attention", "Embedding", "Softmax"}},
+			rules: map[string]lintSeverity{lintOptionCount: lintOff},
+			want:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := maps.Clone(defaultLintRules)
+			maps.Copy(rules, test.rules)
+
+			var got []string
+			for _, finding := range lintAssessment(test.v, rules) {
+				got = append(got, finding.Rule)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("lintAssessment() rules = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLintRules(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		want    map[string]lintSeverity
+		wantErr bool
+	}{
+		{value: "", want: map[string]lintSeverity{}},
+		{value: "option-length=error", want: map[string]lintSeverity{lintOptionLength: lintError}},
+		{value: " negative-stem = OFF , option-count=warn", want: map[string]lintSeverity{lintNegativeStem: lintOff, lintOptionCount: lintWarn}},
+		{value: "option-length", wantErr: true},
+		{value: "no-such-rule=warn", wantErr: true},
+		{value: "option-length=fatal", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			rules := make(map[string]lintSeverity)
+			err := parseLintRules(test.value, rules)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseLintRules(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			}
+			if !test.wantErr && !maps.Equal(rules, test.want) {
+				t.Errorf("parseLintRules(%q) = %v, want %v", test.value, rules, test.want)
+			}
+		})
+	}
+}
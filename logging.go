@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// newLogger builds the run logger. Every record carries the run ID; the
+// workers add subject, topic, proficiency, complexity, model and phase, and
+// each call adds attempt and latency.
+func newLogger(w io.Writer, format string, level string, runID string) (*slog.Logger, error) {
+
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q: %w", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("log format %q: expected text or json", format)
+	}
+
+	return slog.New(handler).With("run_id", runID), nil
+}
+
+func cellLogger(logger *slog.Logger, phase string, llmName string, subject string, topic string, proficiency string, complexity string) *slog.Logger {
+	return logger.With("phase", phase, "model", llmName, "subject", subject, "topic", topic,
+		"proficiency", proficiency, "complexity", complexity)
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
@@ -35,6 +39,54 @@ type assessmentData struct {
 	LLMName     string
 }
 
+const (
+	generationLLMName = "gemini-1.5-flash"
+	validationLLMName = "gemini-1.5-flash-8b"
+)
+
+// llmResponse pairs a response with the channel input that produced it. Resp
+// is nil when the call failed for good, Err then says why.
+type llmResponse struct {
+	Input         []string
+	Resp          *genai.GenerateContentResponse
+	Err           error
+	Stats         callStats
+	Revised       *genai.GenerateContentResponse
+	RevisionStats callStats
+}
+
+// runServices are shared by every worker of a run.
+type runServices struct {
+	client       *genai.Client
+	policy       retryPolicy
+	limiters     *rateLimiters
+	failures     *failureLog
+	safetyLog    *safetyLog
+	journal      *runJournal
+	ledger       *usageLedger
+	budget       *budgetGuard
+	logger       *slog.Logger
+	metrics      *runMetrics
+	progress     *progressTracker
+	safety       []*genai.SafetySetting
+	safetyFlagAt genai.HarmProbability
+	lint         map[string]lintSeverity
+	revise       bool
+	objectives   map[string][]learningObjective
+	sources      map[string]*sourceIndex
+	sourceChunks int
+	topicRows    map[string]topicRow
+}
+
+// validationBatch is the validation prompt built from one generation cell.
+type validationBatch struct {
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	Prompt      string
+}
+
 type assessmentDataforMap struct {
 	Subject              string
 	Topic                string
@@ -49,6 +101,24 @@ type assessmentDataforMap struct {
 	ValidatedAnswer      string
 	ValidatedReasoning   string
 	ValidatedSelectedLLM string
+	FinishReason         string
+	SafetyReview         string
+	Lint                 string
+	RequestedComplexity  string
+	EmpiricalComplexity  string
+	Calibration          string
+	Revision             string
+	BloomLevel           string
+	Objectives           []string
+	SourceChunk          string
+	Citation             string
+	JudgeClarity         int
+	JudgeRelevance       int
+	JudgeProficiencyFit  int
+	JudgeDistractors     int
+	JudgeReasoning       int
+	JudgeScore           float64
+	JudgeComment         string
 }
 
 var systemPrompt = `You are an AI Guru and an expert in AI literature. You are tasked to generate a set of multiple choice assessments 
@@ -73,6 +143,9 @@ You will be prompted with a set of questions and a set of options for each quest
 that accurately reflects the ask and also articulate why its the right answer. If you do not know the answer to any question, 
 please say I do not know. If the right accurate option for the question does not exist, please say The right option is not listed`
 
+// getPromptRefinedforValidation lists the questions for the validator.
+// Questions without exactly four options cannot be listed and are left out;
+// the prompt is empty when none is left.
 func getPromptRefinedforValidation(allQuizes []assessmentDataforMap) string {
 
 	var promptTemplate string
@@ -100,6 +173,9 @@ func getPromptRefinedforValidation(allQuizes []assessmentDataforMap) string {
 	###`
 
 	for outIdx := 0; outIdx < len(allQuizes); outIdx++ {
+		if len(allQuizes[outIdx].AllOptions) != 4 {
+			continue
+		}
 		stepsPromptInit := fmt.Sprintf(stepsPrompt, outIdx, allQuizes[outIdx].Question, allQuizes[outIdx].AllOptions[0],
 			allQuizes[outIdx].AllOptions[1], allQuizes[outIdx].AllOptions[2], allQuizes[outIdx].AllOptions[3])
 		stepsSequencePrompt = stepsSequencePrompt + stepsPromptInit
@@ -120,6 +196,10 @@ func getPromptRefinedforValidation(allQuizes []assessmentDataforMap) string {
 	}
 	Return: Array<ValidatedAssessment>`
 
+	if stepsSequencePrompt == "" {
+		return ""
+	}
+
 	promptTemplate = fmt.Sprintf("\n  %s \n %s", stepsSequencePrompt, outputforPrompt)
 
 	return promptTemplate
@@ -141,6 +221,7 @@ func getPromptRefined(assessmentBankCount int, proficiency string, complexity st
 	Step 7) For each Question generated, Estimate the Complexity of the question in terms of Easy, Medium or Difficult.
 	Step 8) For each Question generated, Highlight the Source if any from which the question was articulated, do not hallucinate, if there are no source to highlight say None
 	Step 9) For each Question generated, Highlight the name and version of the LLM that was used
+	Step 10) For each Question generated, Classify the cognitive level the Question tests on Bloom's taxonomy as Remember, Understand, Apply, Analyze, Evaluate or Create
 
 	Return the results using this JSON schema:
 		Assessment = {
@@ -152,6 +233,7 @@ func getPromptRefined(assessmentBankCount int, proficiency string, complexity st
 		'AllOptions':[]
 		'Reasoning': string
 		'Complexity': string
+		'BloomLevel': string
 		'Source': string
 		'LLMName': %s
 		}
@@ -161,112 +243,155 @@ func getPromptRefined(assessmentBankCount int, proficiency string, complexity st
 
 }
 
-func workerforValidation(ctx context.Context, trackerforValdation chan empty, chanInputs chan []string, geminiResponseforValidation chan *genai.GenerateContentResponse, goRoute int) {
+func newLLMClient(ctx context.Context, opts ...option.ClientOption) (*genai.Client, error) {
+	// Access your API key as an environment variable (see "Set up your API key" above)
+	opts = append([]option.ClientOption{option.WithAPIKey(os.Getenv("GEMINI_API_KEY"))}, opts...)
+	return genai.NewClient(ctx, opts...)
+}
 
-	var llmName string
-	for chanInput := range chanInputs {
+func newValidationModel(client *genai.Client, llmName string) *genai.GenerativeModel {
 
-		llmName = "gemini-1.5-flash-8b"
+	model := client.GenerativeModel(llmName)
+	model.ResponseMIMEType = "application/json"
+	const ChatTemperature float32 = 0.0
+	temperature := ChatTemperature
+	model.Temperature = &temperature
 
-		// Access your API key as an environment variable (see "Set up your API key" above)
-		client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
-		if err != nil {
-			log.Fatal(err)
-		}
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(systemPromptForValidation)},
+	}
 
-		model := client.GenerativeModel(llmName)
-		model.ResponseMIMEType = "application/json"
-		const ChatTemperature float32 = 0.0
-		temperature := ChatTemperature
-		model.Temperature = &temperature
+	return model
+}
 
-		model.SystemInstruction = &genai.Content{
-			Parts: []genai.Part{genai.Text(systemPromptForValidation)},
-		}
+func newGenerationModel(client *genai.Client, llmName string, safety []*genai.SafetySetting) *genai.GenerativeModel {
 
-		resp, err := model.GenerateContent(ctx, genai.Text(chanInput[0]))
-		if err != nil {
-			geminiResponseforValidation <- nil
-		} else {
-			geminiResponseforValidation <- resp
-		}
+	model := client.GenerativeModel(llmName)
+	model.ResponseMIMEType = "application/json"
+	const ChatTemperature float32 = 0.0
+	temperature := ChatTemperature
+	model.Temperature = &temperature
 
-		client.Close()
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(systemPrompt)},
 	}
-	var e empty
-	trackerforValdation <- e
 
+	/* 		model.ResponseSchema = &genai.Schema{
+	   			Type:  genai.TypeArray,
+	   			Items: &genai.Schema{Type: genai.TypeString},
+	   		}
+	*/
+	model.SafetySettings = safety
+
+	return model
 }
 
-func worker(ctx context.Context, tracker chan empty, assessmentBankCount int, chanInputs chan []string, geminiResponse chan *genai.GenerateContentResponse, goRoute int) {
+// workerforValidation and worker share the process wide client, so every
+// call reuses its pooled connections; each worker builds its model once.
+func workerforValidation(ctx context.Context, trackerforValdation chan empty, chanInputs chan []string, geminiResponseforValidation chan llmResponse, goRoute int, svc *runServices) {
+
+	llmName := validationLLMName
+	call := llmCall{
+		Model:    newValidationModel(svc.client, llmName),
+		LLMName:  llmName,
+		Phase:    phaseValidation,
+		Policy:   svc.policy,
+		Limiter:  svc.limiters.forModel(llmName),
+		Metrics:  svc.metrics,
+		Progress: svc.progress,
+	}
 
-	var llmName string
 	for chanInput := range chanInputs {
 
-		llmName = "gemini-1.5-flash"
-
-		promptString := getPromptRefined(assessmentBankCount, chanInput[0], chanInput[1], chanInput[2], chanInput[3], llmName)
+		logger := cellLogger(svc.logger, phaseValidation, llmName, chanInput[1], chanInput[2], chanInput[3], chanInput[4])
+		call.Subject = chanInput[1]
+		call.Logger = logger
 
-		// Access your API key as an environment variable (see "Set up your API key" above)
-		client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
+		resp, stats, class, err := generateWithRetry(ctx, call, chanInput[0])
+		svc.ledger.add(phaseValidation, chanInput[1], chanInput[2], llmName, stats)
 		if err != nil {
-			log.Fatal(err)
+			logger.Error("validation batch failed", "attempt", stats.Attempts, "latency", stats.Latency,
+				"class", class.Class.String(), "reason", class.Reason, "err", err)
+			svc.failures.add(failedCell{Phase: phaseValidation, Subject: chanInput[1], Topic: chanInput[2], Proficiency: chanInput[3],
+				Complexity: chanInput[4], Attempts: stats.Attempts, Class: class.Class, Reason: class.Reason, Err: err})
+			if class.Class == errorClassCanceled {
+				continue
+			}
+			resp = nil
 		}
+		geminiResponseforValidation <- llmResponse{Input: chanInput, Resp: resp, Err: err, Stats: stats}
+	}
+	var e empty
+	trackerforValdation <- e
 
-		model := client.GenerativeModel(llmName)
-		model.ResponseMIMEType = "application/json"
-		const ChatTemperature float32 = 0.0
-		temperature := ChatTemperature
-		model.Temperature = &temperature
+}
 
-		model.SystemInstruction = &genai.Content{
-			Parts: []genai.Part{genai.Text(systemPrompt)},
-		}
+func worker(ctx context.Context, tracker chan empty, assessmentBankCount int, chanInputs chan []string, geminiResponse chan llmResponse, goRoute int, svc *runServices) {
+
+	llmName := generationLLMName
+	call := llmCall{
+		Model:    newGenerationModel(svc.client, llmName, svc.safety),
+		LLMName:  llmName,
+		Phase:    phaseGeneration,
+		Policy:   svc.policy,
+		Limiter:  svc.limiters.forModel(llmName),
+		Metrics:  svc.metrics,
+		Progress: svc.progress,
+	}
+
+	for chanInput := range chanInputs {
+
+		row := svc.topicRows[chanInput[2]+"-"+chanInput[3]]
+		promptString := withTopicGuidance(getPromptRefined(row.bankCount(assessmentBankCount), chanInput[0], chanInput[1], chanInput[2],
+			chanInput[3], llmName), row)
+		promptString = withSources(promptString, svc.sources[chanInput[2]+"-"+chanInput[3]].forCell(chanInput[0], chanInput[1], svc.sourceChunks))
+		promptString = withAvoidList(withObjectives(promptString, svc.objectives[chanInput[2]+"-"+chanInput[3]]), chanInput[4])
+
+		logger := cellLogger(svc.logger, phaseGeneration, llmName, chanInput[2], chanInput[3], chanInput[0], chanInput[1])
+		call.Subject = chanInput[2]
+		call.Logger = logger
 
-		/* 		model.ResponseSchema = &genai.Schema{
-		   			Type:  genai.TypeArray,
-		   			Items: &genai.Schema{Type: genai.TypeString},
-		   		}
-		*/
-		/* 		model.SafetySettings = []*genai.SafetySetting{
-			{
-				Category:  genai.HarmCategoryDangerous,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategoryDangerousContent,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategoryHarassment,
-				Threshold: genai.HarmBlockNone,
-			},
-			{
-				Category:  genai.HarmCategoryHateSpeech,
-				Threshold: genai.HarmBlockNone,
-			},
-		} */
-
-		resp, err := model.GenerateContent(ctx, genai.Text(promptString))
+		resp, stats, class, err := generateWithRetry(ctx, call, promptString)
+		svc.ledger.add(phaseGeneration, chanInput[2], chanInput[3], llmName, stats)
 		if err != nil {
-			geminiResponse <- nil
-		} else {
-			geminiResponse <- resp
+			logger.Error("generation cell failed", "attempt", stats.Attempts, "latency", stats.Latency,
+				"class", class.Class.String(), "reason", class.Reason, "err", err)
+			svc.failures.add(failedCell{Phase: phaseGeneration, Subject: chanInput[2], Topic: chanInput[3], Proficiency: chanInput[0],
+				Complexity: chanInput[1], Attempts: stats.Attempts, Class: class.Class, Reason: class.Reason, Err: err})
+			if class.Class == errorClassCanceled {
+				continue
+			}
+			resp = nil
 		}
 
-		client.Close()
+		generationErr := err
+		var revised *genai.GenerateContentResponse
+		var revisionStats callStats
+		if svc.revise && resp != nil {
+			revisionCall := call
+			revisionCall.Phase = phaseRevision
+			revisionCall.Logger = cellLogger(svc.logger, phaseRevision, llmName, chanInput[2], chanInput[3], chanInput[0], chanInput[1])
+			revisionPrompt := getPromptForRevision(chanInput[0], chanInput[1], chanInput[2], chanInput[3], responseText(resp))
+			revised, revisionStats, class, err = generateWithRetry(ctx, revisionCall, revisionPrompt)
+			svc.ledger.add(phaseRevision, chanInput[2], chanInput[3], llmName, revisionStats)
+			if err != nil {
+				revisionCall.Logger.Warn("revision failed, keeping the generated batch", "attempt", revisionStats.Attempts,
+					"class", class.Class.String(), "reason", class.Reason, "err", err)
+				revised = nil
+			}
+		}
+		geminiResponse <- llmResponse{Input: chanInput, Resp: resp, Err: generationErr, Stats: stats, Revised: revised,
+			RevisionStats: revisionStats}
 	}
 	var e empty
 	tracker <- e
 
 }
 
-func getAllResponseMap(debug bool, resp *genai.GenerateContentResponse) (map[string]assessmentDataforMap, string) {
+func getAllResponseMap(logger *slog.Logger, resp *genai.GenerateContentResponse) map[string]assessmentDataforMap {
 
 	resultsMap := make(map[string]assessmentDataforMap)
 
-	var promptforValidation string
-
 	for _, cand := range resp.Candidates {
 		if cand.Content != nil {
 			for _, part := range cand.Content.Parts {
@@ -274,19 +399,13 @@ func getAllResponseMap(debug bool, resp *genai.GenerateContentResponse) (map[str
 					var dataString []assessmentDataforMap
 					if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
 						//log.Fatal(err)
-						fmt.Println(err)
+						logger.Warn("unparseable generation response", "err", err)
 						continue
 					} else {
-						// fmt.Println("Len of dataString :", len(dataString))
-						if dataString != nil {
-
-							if debug {
-								fmt.Println("-----------------------------------------------------------------")
-								fmt.Println(dataString[0].Proficiency, dataString[0].Complexity, dataString[0].Topic, len(dataString))
-								fmt.Println("-----------------------------------------------------------------")
-							}
+						if len(dataString) > 0 {
 
-							promptforValidation = getPromptRefinedforValidation(dataString)
+							logger.Debug("parsed generation response", "returned_proficiency", dataString[0].Proficiency,
+								"returned_complexity", dataString[0].Complexity, "returned_topic", dataString[0].Topic, "questions", len(dataString))
 
 							for idx := 0; idx < len(dataString); idx++ {
 								resultsMap[dataString[idx].Question] = dataString[idx]
@@ -299,10 +418,10 @@ func getAllResponseMap(debug bool, resp *genai.GenerateContentResponse) (map[str
 		}
 	}
 
-	return resultsMap, promptforValidation
+	return resultsMap
 }
 
-func getAllValidatedResponseMap(resp *genai.GenerateContentResponse) map[string]assessmentValidatedData {
+func getAllValidatedResponseMap(logger *slog.Logger, resp *genai.GenerateContentResponse) map[string]assessmentValidatedData {
 
 	validatedResultsMap := make(map[string]assessmentValidatedData)
 
@@ -312,11 +431,11 @@ func getAllValidatedResponseMap(resp *genai.GenerateContentResponse) map[string]
 				if txt, ok := part.(genai.Text); ok {
 					var dataString []assessmentValidatedData
 					if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
-						fmt.Println(err)
+						logger.Warn("unparseable validation response", "err", err)
 						continue
 					} else {
-						// fmt.Println("Len of dataString :", len(dataString))
 						if dataString != nil {
+							logger.Debug("parsed validation response", "questions", len(dataString))
 							for idx := 0; idx < len(dataString); idx++ {
 								validatedResultsMap[dataString[idx].Question] = dataString[idx]
 							}
@@ -331,85 +450,169 @@ func getAllValidatedResponseMap(resp *genai.GenerateContentResponse) map[string]
 	return validatedResultsMap
 }
 
-func mergeFiles(fileName string) {
+// mergedHeader is the header row of the merged assessment banks.
+func mergedHeader(sep string) string {
+	return "Subject" + sep + "Topic" + sep + "Proficiency" + sep + "Complexity" + sep +
+		"Question" + sep + "Option1" + sep + "Option2" + sep + "Option3" + sep + "Option4" + sep + "Answer" + sep +
+		"Reasoning" + sep + "Source" + sep + "LLMName" + sep + "ValidatedAnswer" + sep + "ValidatedReasoning" + sep + "ValidatedSelectedLLM" + sep + "FinishReason" + sep + "SafetyReview" + sep + "Lint" + sep +
+		"RequestedComplexity" + sep + "EmpiricalComplexity" + sep + "Calibration" + sep + "Revision" + sep +
+		"BloomLevel" + sep + "Objectives" + sep + "SourceChunk" + sep + "Citation" + sep +
+		"JudgeClarity" + sep + "JudgeRelevance" + sep + "JudgeProficiencyFit" + sep + "JudgeDistractors" + sep +
+		"JudgeReasoning" + sep + "JudgeScore" + sep + "JudgeComment"
+}
 
-	file, err := os.Create(fileName)
+// mergedFileNames are the banks mergeFiles writes for the topics: one per
+// subject, plus allFileName when set.
+func mergedFileNames(record [][]string, allFileName string) []string {
+
+	var fileNames []string
+	for _, row := range record {
+		if fileName := row[0] + "-" + "Validated.csv"; !slices.Contains(fileNames, fileName) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	if allFileName != "" {
+		fileNames = append(fileNames, allFileName)
+	}
 
-	var dataStringSlice, validatedAssessmentfileName string
+	return fileNames
+}
+
+func writeMergedFile(logger *slog.Logger, fileName string, header string, lines []string, topics int) {
 
+	file, err := os.Create(fileName)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("merged assessment bank not written", "file", fileName, "err", err)
+		return
 	}
 	defer file.Close()
-	sep := ";"
 
-	dataStringSlice = "Subject" + sep + "Topic" + sep + "Proficiency" + sep + "Complexity" + sep +
-		"Question" + sep + "Option1" + sep + "Option2" + sep + "Option3" + sep + "Option4" + sep + "Answer" + sep +
-		"Reasoning" + sep + "Source" + sep + "LLMName" + sep + "ValidatedAnswer" + sep + "ValidatedReasoning" + sep + "ValidatedSelectedLLM" + "\n"
+	file.WriteString(header + "\n")
+	for _, line := range lines {
+		file.WriteString(line + "\n")
+	}
 
-	file.WriteString(dataStringSlice)
+	file.Sync()
 
-	csvfile, err := os.Open("TopicsforAssessmentGeneration.csv")
-	if err != nil {
-		log.Fatalln("Couldn't open the csv file", err)
-	}
-	defer csvfile.Close()
+	logger.Info("merged assessment bank written", "file", fileName, "topics", topics, "rows", len(lines))
+}
 
-	// Parse the file
-	r := csv.NewReader(csvfile)
+// mergeFiles writes one bank per subject, <Subject>-Validated.csv, from the
+// validated assessment files of its topics, and when allFileName is set one
+// bank of every subject. Header rows found in the inputs are dropped, and
+// missing or empty inputs are reported.
+func mergeFiles(logger *slog.Logger, record [][]string, allFileName string) {
 
-	record, _ := r.ReadAll()
+	sep := ";"
+	header := mergedHeader(sep)
 
-	for recordIteration := range record {
+	var subjects []string
+	topicsBySubject := make(map[string][]string)
+	for _, row := range record {
+		if _, ok := topicsBySubject[row[0]]; !ok {
+			subjects = append(subjects, row[0])
+		}
+		topicsBySubject[row[0]] = append(topicsBySubject[row[0]], row[1])
+	}
 
-		validatedAssessmentfileName = record[recordIteration][0] + "-" + record[recordIteration][1] + "-" + "ValidatedAssessment.csv"
+	var allLines, missing, emptyFiles []string
+	for _, subject := range subjects {
 
-		content, _ := os.ReadFile(validatedAssessmentfileName)
+		var lines []string
+		for _, topic := range topicsBySubject[subject] {
 
-		lines := strings.Split(string(content), "\n")
+			validatedAssessmentfileName := subject + "-" + topic + "-" + "ValidatedAssessment.csv"
+
+			content, err := os.ReadFile(validatedAssessmentfileName)
+			if err != nil {
+				logger.Warn("missing validated assessment file", "subject", subject, "topic", topic,
+					"file", validatedAssessmentfileName, "err", err)
+				missing = append(missing, validatedAssessmentfileName)
+				continue
+			}
 
-		for idx := range lines {
-			if len(lines[idx]) > 0 {
-				file.WriteString(lines[idx] + "\n")
+			merged := 0
+			for _, line := range strings.Split(string(content), "\n") {
+				line = strings.TrimRight(line, "\r")
+				if len(line) == 0 || strings.HasPrefix(line, "Subject"+sep+"Topic"+sep) {
+					continue
+				}
+				lines = append(lines, line)
+				merged++
+			}
+			if merged == 0 {
+				logger.Warn("empty validated assessment file", "subject", subject, "topic", topic, "file", validatedAssessmentfileName)
+				emptyFiles = append(emptyFiles, validatedAssessmentfileName)
 			}
+
+			logger.Debug("merged validated assessment file", "subject", subject, "topic", topic,
+				"file", validatedAssessmentfileName, "rows", merged)
 		}
 
+		writeMergedFile(logger, subject+"-"+"Validated.csv", header, lines, len(topicsBySubject[subject]))
+		allLines = append(allLines, lines...)
 	}
 
-	file.Sync()
+	if allFileName != "" {
+		writeMergedFile(logger, allFileName, header, allLines, len(record))
+	}
+
+	if len(missing)+len(emptyFiles) == 0 {
+		return
+	}
 
+	fmt.Println("Merge Report")
+	fmt.Println("----------------------------------------------------")
+	for _, fileName := range missing {
+		fmt.Println("missing", fileName)
+	}
+	for _, fileName := range emptyFiles {
+		fmt.Println("empty  ", fileName)
+	}
+	fmt.Println("----------------------------------------------------")
 }
 
-func csvWriteStringFile(resultsMap map[string]assessmentDataforMap, fileName string) {
+// csvWriteStringFile writes the questions of a topic as an assessment file.
+// It runs on the collector goroutines too, so it returns its error for the
+// caller to log rather than stopping the run.
+func csvWriteStringFile(resultsMap map[string]assessmentDataforMap, fileName string) error {
 
 	file, err := os.Create(fileName)
 
 	var dataStringSlice string
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 	sep := ";"
 
 	for _, v := range resultsMap {
 
+		// Questions rejected by the option-count lint rule are written too
+		options := append(slices.Clone(v.AllOptions), make([]string, 4)...)
+
 		dataStringSlice = v.Subject + sep + v.Topic + sep +
 			v.Proficiency + sep + v.Complexity + sep +
-			v.Question + sep + v.AllOptions[0] + sep +
-			v.AllOptions[1] + sep + v.AllOptions[2] + sep +
-			v.AllOptions[3] + sep + v.Answer + sep +
+			v.Question + sep + options[0] + sep +
+			options[1] + sep + options[2] + sep +
+			options[3] + sep + v.Answer + sep +
 			v.Reasoning + sep + v.Source + sep +
-			v.LLMName + sep + v.ValidatedAnswer + sep + v.ValidatedReasoning + sep + v.ValidatedSelectedLLM + "\n"
-
-		file.WriteString(dataStringSlice)
+			v.LLMName + sep + v.ValidatedAnswer + sep + v.ValidatedReasoning + sep + v.ValidatedSelectedLLM + sep +
+			v.FinishReason + sep + v.SafetyReview + sep + v.Lint + sep +
+			v.RequestedComplexity + sep + v.EmpiricalComplexity + sep + v.Calibration + sep + v.Revision + sep +
+			v.BloomLevel + sep + strings.Join(v.Objectives, ",") + sep + v.SourceChunk + sep + v.Citation + sep +
+			judgeColumns(v, sep) + "\n"
+
+		if _, err := file.WriteString(dataStringSlice); err != nil {
+			return err
+		}
 	}
 
-	file.Sync()
-
+	return file.Sync()
 }
 
-func updateMaps(debug bool, resultsMap map[string]assessmentDataforMap, allValidatedResultsMap map[string]assessmentValidatedData) (map[string]assessmentDataforMap, []assessmentDataforMap) {
+func updateMaps(logger *slog.Logger, resultsMap map[string]assessmentDataforMap, allValidatedResultsMap map[string]assessmentValidatedData) (map[string]assessmentDataforMap, []assessmentDataforMap) {
 
 	var localAssessmentDataforMap assessmentDataforMap
 	var mismatchedDataString []assessmentDataforMap
@@ -431,7 +634,7 @@ func updateMaps(debug bool, resultsMap map[string]assessmentDataforMap, allValid
 
 				localAssessmentDataforMap.ValidatedAnswer = vin.ValidatedAnswer
 				localAssessmentDataforMap.ValidatedReasoning = vin.ValidatedReasoning
-				localAssessmentDataforMap.ValidatedSelectedLLM = "gemini-1.5-flash-8b"
+				localAssessmentDataforMap.ValidatedSelectedLLM = validationLLMName
 
 				resultsMapCopy[kout] = localAssessmentDataforMap
 
@@ -439,19 +642,9 @@ func updateMaps(debug bool, resultsMap map[string]assessmentDataforMap, allValid
 					correctAnswer++
 				} else {
 					mismatchedDataString = append(mismatchedDataString, localAssessmentDataforMap)
-					if debug {
-						fmt.Println("----------------------------------------------------")
-						fmt.Println("Question")
-						fmt.Println("----------------------------------------------------")
-						fmt.Println(vout.Question)
-						fmt.Println("----------------------------------------------------")
-						fmt.Println(vout.ValidatedAnswer, vout.Answer)
-						fmt.Println("----------------------------------------------------")
-						fmt.Println(vout.ValidatedReasoning)
-						fmt.Println("----------------------------------------------------")
-						fmt.Println(vout.Reasoning)
-						fmt.Println("----------------------------------------------------")
-					}
+					logger.Debug("validator disagrees with answer key", "proficiency", vout.Proficiency, "complexity", vout.Complexity,
+						"question", vout.Question, "answer", vout.Answer, "validated_answer", localAssessmentDataforMap.ValidatedAnswer,
+						"reasoning", vout.Reasoning, "validated_reasoning", localAssessmentDataforMap.ValidatedReasoning)
 				}
 
 				continue
@@ -467,216 +660,320 @@ func updateMaps(debug bool, resultsMap map[string]assessmentDataforMap, allValid
 		matchFound = false
 	}
 
-	if debug {
-		fmt.Println("----------------------------------------------------")
-		fmt.Println("Correct Anwers :", correctAnswer, " Total :", len(resultsMap), "  No Match : ", doesNotMatch, " Mismatched :", len(mismatchedDataString))
-		fmt.Println("----------------------------------------------------")
-	}
+	logger.Debug("validation compared", "correct", correctAnswer, "total", len(resultsMap), "no_match", doesNotMatch,
+		"mismatched", len(mismatchedDataString))
 
 	return resultsMapCopy, mismatchedDataString
 }
 
-func validateAsessments(ctx context.Context, debug bool, goRoutineCount int, promptforValidationList []string) map[string]assessmentValidatedData {
-	var dataInput []string
-	trackerforValdation := make(chan empty)
-	chanInputsforValidation := make(chan []string)
-	geminiResponseforValidation := make(chan *genai.GenerateContentResponse)
-	var allValidatedResultsMap map[string]assessmentValidatedData
-
-	// Create the jobs
-	for i := 0; i < goRoutineCount; i++ {
-		go workerforValidation(ctx, trackerforValdation, chanInputsforValidation, geminiResponseforValidation, i)
-	}
-
-	//get the completions
-	go func() {
-		for r := range geminiResponseforValidation {
-			rvMap := getAllValidatedResponseMap(r)
+func main() {
 
-			if allValidatedResultsMap == nil {
-				allValidatedResultsMap = maps.Clone(rvMap)
-			} else {
-				maps.Copy(allValidatedResultsMap, rvMap)
-			}
+	policy := defaultRetryPolicy
+	flag.IntVar(&policy.MaxAttempts, "max-attempts", policy.MaxAttempts, "maximum attempts per LLM call")
+	flag.DurationVar(&policy.BaseDelay, "retry-base-delay", policy.BaseDelay, "initial retry backoff")
+	flag.DurationVar(&policy.MaxDelay, "retry-max-delay", policy.MaxDelay, "maximum retry backoff")
+	flag.DurationVar(&policy.RequestTimeout, "request-timeout", policy.RequestTimeout, "timeout of a single LLM call")
+	limits := maps.Clone(defaultModelLimits)
+	flag.Func("rate-limits", "per model limits as model=rpm:tpm[,model=rpm:tpm]", func(value string) error {
+		return parseModelLimits(value, limits)
+	})
+	var runID string
+	flag.StringVar(&runID, "run-id", "", "run identifier; reuse the ID of an interrupted run to resume it")
+	prices := maps.Clone(defaultModelPrices)
+	flag.Func("prices", "per model USD per million tokens as model=input:output[,model=input:output]", func(value string) error {
+		return parseModelPrices(value, prices)
+	})
+	var limitsBudget budgetLimits
+	flag.Float64Var(&limitsBudget.MaxRunCost, "max-run-cost", 0, "stop calling models once the run would cost more USD (0 = no cap)")
+	flag.Int64Var(&limitsBudget.MaxRunTokens, "max-run-tokens", 0, "stop calling models once the run would use more tokens (0 = no cap)")
+	flag.Float64Var(&limitsBudget.MaxSubjectCost, "max-subject-cost", 0, "stop calling models for a subject once it would cost more USD (0 = no cap)")
+	flag.Int64Var(&limitsBudget.MaxSubjectTokens, "max-subject-tokens", 0, "stop calling models for a subject once it would use more tokens (0 = no cap)")
+	flag.Func("concurrency", "per model calls in flight as model=n[,model=n]", func(value string) error {
+		return parseModelConcurrency(value, limits)
+	})
+	var logFormat, logLevel string
+	flag.StringVar(&logFormat, "log-format", "text", "log handler: text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	var metricsAddr string
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9090 (disabled when empty)")
+	var progressInterval time.Duration
+	flag.DurationVar(&progressInterval, "progress-interval", 30*time.Second, "how often progress is logged when stdout is not a terminal")
+	safetySettings := make(map[genai.HarmCategory]genai.HarmBlockThreshold)
+	flag.Func("safety", "generation safety thresholds as category=threshold[,category=threshold]; categories are harassment, "+
+		"hate_speech, sexually_explicit and dangerous_content, thresholds block_none, block_only_high, block_medium_and_above "+
+		"and block_low_and_above", func(value string) error {
+		return parseSafetySettings(value, safetySettings)
+	})
+	safetyFlagAt := genai.HarmProbabilityMedium
+	flag.Func("safety-flag-at", "flag questions for review from this safety rating: low, medium or high (default medium)", func(value string) error {
+		probability, ok := safetyProbabilities[strings.ToLower(value)]
+		if !ok {
+			return fmt.Errorf("safety rating %q: expected low, medium or high", value)
 		}
-		var e empty
-		trackerforValdation <- e
-	}()
-
-	for pidx := range promptforValidationList {
-		dataInput = nil
-
-		dataInput = append(dataInput, promptforValidationList[pidx])
-
-		chanInputsforValidation <- dataInput
-
+		safetyFlagAt = probability
+		return nil
+	})
+	var dedupThreshold float64
+	flag.Float64Var(&dedupThreshold, "dedup-threshold", 0.8, "drop questions whose shingle similarity to another one "+
+		"reaches this share, between 0 and 1 (0 disables)")
+	var questionBank string
+	flag.StringVar(&questionBank, "question-bank", "", "glob of earlier merged assessment files to check new questions against")
+	var avoidTokens int
+	flag.IntVar(&avoidTokens, "avoid-stem-tokens", 400, "token budget of known question stems listed in each generation prompt (0 disables)")
+	lintRules := maps.Clone(defaultLintRules)
+	flag.Func("lint", "lint rule severities as rule=off|info|warn|error[,rule=severity]; questions with errors are not validated",
+		func(value string) error {
+			return parseLintRules(value, lintRules)
+		})
+	var topicsFile string
+	flag.StringVar(&topicsFile, "topics", "TopicsforAssessmentGeneration.csv", "topics to generate: a CSV, either Subject,Topic rows "+
+		"or with a header of "+strings.Join(topicColumns, ", ")+", or a JSON or YAML list of the same fields")
+	var mergeAll string
+	flag.StringVar(&mergeAll, "merge-all", "", "also merge the banks of every subject into this file, e.g. All-Validated.csv; "+
+		"it must not be named like a subject bank")
+	var objectivesFile string
+	flag.StringVar(&objectivesFile, "objectives", "", "CSV of learning objectives as Subject,Topic,ObjectiveID,Objective to tag questions with; "+
+		"sub-topics use their Topic's objectives unless listed as Topic - SubTopic")
+	var sourcesFile string
+	flag.StringVar(&sourcesFile, "sources", "", "CSV of reference material as Subject,Topic,Glob to ground generation in "+
+		"(Markdown, text, HTML or text extracted from PDFs); sub-topics use their Topic's material unless listed as "+
+		"Topic - SubTopic")
+	var sourceChunks int
+	flag.IntVar(&sourceChunks, "source-chunks", 4, "reference passages quoted in each generation prompt")
+	var citationModel string
+	flag.StringVar(&citationModel, "citation-model", "", "model that checks each grounded question's cited passage supports "+
+		"its answer, e.g. gemini-1.5-pro (lexical overlap only when empty)")
+	var citationMinOverlap float64
+	flag.Float64Var(&citationMinOverlap, "citation-min-overlap", 0.3, "share of question and answer words a cited passage needs "+
+		"to count as supporting, with or without an entailment check")
+	var revise bool
+	flag.BoolVar(&revise, "revise", false, "have the generation model critique and revise each batch before it is validated")
+	var calibrationModels string
+	flag.StringVar(&calibrationModels, "calibration-models", "", "comma separated models that also answer every validation batch "+
+		"to measure question difficulty, e.g. gemini-1.5-flash,gemini-1.5-pro; labels are only judged on at least 2 probes, "+
+		"the validator included, weighted by model strength")
+	var calibrationRelabel bool
+	flag.BoolVar(&calibrationRelabel, "calibration-relabel", false, "replace complexity labels contradicted by the calibration evidence")
+	var judgeModel string
+	flag.StringVar(&judgeModel, "judge-model", "", "model that rates every kept question 1-5 on clarity, relevance, proficiency fit, "+
+		"distractors and reasoning, e.g. gemini-1.5-pro (disabled when empty)")
+	var syllabusSubject, syllabusProficiencies, syllabusOut string
+	var syllabusTopics int
+	flag.StringVar(&syllabusSubject, "syllabus", "", "propose the topics of this Subject for review, write them as a topics file and exit")
+	flag.StringVar(&syllabusProficiencies, "syllabus-proficiencies", "Learner-Specialist", "proficiencies the syllabus targets, "+
+		"as a range such as Learner-Practitioner or a list such as Learner|Specialist")
+	flag.IntVar(&syllabusTopics, "syllabus-topics", 8, "about how many topics the syllabus proposes")
+	flag.StringVar(&syllabusOut, "syllabus-out", "", "topics file the syllabus is written to, CSV, JSON or YAML by extension "+
+		"(default <Subject>-Topics.csv)")
+	var force bool
+	flag.BoolVar(&force, "force", false, "let -syllabus replace an existing -syllabus-out file")
+	flag.Parse()
+
+	if runID == "" {
+		runID = newRunID()
 	}
 
-	close(chanInputsforValidation)
-	for i := 0; i < goRoutineCount; i++ {
-		<-trackerforValdation
+	logger, err := newLogger(os.Stderr, logFormat, logLevel, runID)
+	if err != nil {
+		log.Fatalln(err)
 	}
-	close(geminiResponseforValidation)
+	slog.SetDefault(logger)
 
-	<-trackerforValdation
-
-	return allValidatedResultsMap
-}
+	if dedupThreshold < 0 || dedupThreshold > 1 {
+		log.Fatalln("-dedup-threshold must be between 0 and 1, got", dedupThreshold)
+	}
 
-func generateAssessments(ctx context.Context, debug bool, goRoutineCount int, record [][]string) (map[string]assessmentDataforMap, []string) {
+	if syllabusSubject != "" {
+		proficiencies, err := parseProficiencyRange(syllabusProficiencies)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if syllabusOut == "" {
+			syllabusOut = syllabusSubject + "-Topics.csv"
+		}
+		client, err := newLLMClient(context.Background())
+		if err != nil {
+			log.Fatalln("Couldn't create the LLM client", err)
+		}
+		defer client.Close()
+		call := llmCall{
+			Model:   newSyllabusModel(client, generationLLMName),
+			LLMName: generationLLMName,
+			Phase:   phaseSyllabus,
+			Policy:  policy,
+			Limiter: newRateLimiters(limits).forModel(generationLLMName),
+		}
+		err = expandSyllabus(withShutdownSignals(context.Background()), logger, call, syllabusSubject, proficiencies, syllabusTopics,
+			syllabusOut, force)
+		if err != nil {
+			log.Fatalln("Couldn't expand the syllabus", err)
+		}
+		return
+	}
 
-	var resultsMap map[string]assessmentDataforMap
-	var promptforValidationList []string
+	logger.Info("run started", "resume_with", "-run-id "+runID)
 
-	const assessmentBankCount int = 3
+	journal, err := openRunJournal(runID)
+	if err != nil {
+		log.Fatalln("Couldn't open the run journal", err)
+	}
+	defer journal.Close()
 
-	var profList []string
+	var failures failureLog
+	limiters := newRateLimiters(limits)
 
-	profList = nil
-	profList = append(profList, "Learner")
-	profList = append(profList, "Practitioner")
-	profList = append(profList, "Specialist")
+	ctx := withShutdownSignals(context.Background())
 
-	var complexityList []string
+	client, err := newLLMClient(context.Background())
+	if err != nil {
+		log.Fatalln("Couldn't create the LLM client", err)
+	}
+	defer client.Close()
 
-	complexityList = nil
-	complexityList = append(complexityList, "Easy")
-	complexityList = append(complexityList, "Medium")
-	complexityList = append(complexityList, "Difficult")
+	rows, err := loadTopics(topicsFile)
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			logger.Error("invalid topics file", "err", line)
+		}
+		log.Fatalln("Couldn't read the topics file", topicsFile)
+	}
+	record := topicRecords(rows)
+	if mergeAll != "" && slices.Contains(mergedFileNames(record, ""), filepath.Clean(mergeAll)) {
+		log.Fatalln("-merge-all", mergeAll, "would overwrite the merged bank of its subject")
+	}
+	topicRows := make(map[string]topicRow)
+	for _, row := range rows {
+		topicRows[row.Subject+"-"+row.qualifiedTopic()] = row
+	}
 
-	geminiResponse := make(chan *genai.GenerateContentResponse)
+	var objectives map[string][]learningObjective
+	if objectivesFile != "" {
+		objectives, err = loadObjectives(objectivesFile)
+		if err != nil {
+			log.Fatalln("Couldn't read the objectives file", err)
+		}
+	}
 
-	// begin Implementation 2
+	var sources map[string]*sourceIndex
+	if sourcesFile != "" {
+		sources, err = loadSourceIndexes(logger, sourcesFile)
+		if err != nil {
+			log.Fatalln("Couldn't read the sources file", err)
+		}
+	}
 
-	tracker := make(chan empty)
-	chanInputs := make(chan []string)
-	var dataInput []string
+	// sub-topics without their own objectives or sources use their topic's,
+	// with the passages ranked again for the sub-topic
+	for key, parent := range parentTopics(rows) {
+		if _, ok := objectives[key]; !ok && objectives[parent] != nil {
+			objectives[key] = objectives[parent]
+		}
+		if _, ok := sources[key]; !ok && sources[parent] != nil {
+			sources[key] = newSourceIndex(sources[parent].chunks, strings.ReplaceAll(key, "-", " "))
+			logger.Info("reference material inherited", "topic", key, "from", parent)
+		}
+	}
 
-	// Create the jobs
-	for i := 0; i < goRoutineCount; i++ {
-		go worker(ctx, tracker, assessmentBankCount, chanInputs, geminiResponse, i)
+	logger.Info("generating and validating assessments", "topics", len(record))
+	svc := &runServices{
+		client:       client,
+		policy:       policy,
+		limiters:     limiters,
+		failures:     &failures,
+		safetyLog:    &safetyLog{},
+		journal:      journal,
+		ledger:       newUsageLedger(prices),
+		logger:       logger,
+		progress:     newProgressTracker(),
+		safety:       safetySettingsList(safetySettings),
+		safetyFlagAt: safetyFlagAt,
+		lint:         lintRules,
+		revise:       revise,
+		objectives:   objectives,
+		sources:      sources,
+		sourceChunks: sourceChunks,
+		topicRows:    topicRows,
+	}
+	if metricsAddr != "" {
+		metricsCtx, stopMetrics := context.WithCancel(context.Background())
+		defer stopMetrics()
+		svc.metrics = newRunMetrics()
+		svc.metrics.serve(metricsCtx, metricsAddr)
 	}
+	svc.budget = newBudgetGuard(limitsBudget, svc.ledger, prices)
 
-	//get the completions
+	progressCtx, stopProgress := context.WithCancel(context.Background())
+	progressDone := make(chan empty)
 	go func() {
-		for r := range geminiResponse {
-
-			rMap, promptforValidation := getAllResponseMap(debug, r)
-
-			promptforValidationList = append(promptforValidationList, promptforValidation)
-
-			if resultsMap == nil {
-				resultsMap = maps.Clone(rMap)
-			} else {
-				maps.Copy(resultsMap, rMap)
-			}
-		}
-		var e empty
-		tracker <- e
+		svc.progress.display(progressCtx, logger, progressInterval)
+		close(progressDone)
 	}()
 
-	for idx := range profList {
-
-		for cidx := range complexityList {
+	sched := newScheduler(svc)
+	if questionBank != "" {
+		var exclude []string
+		for _, fileName := range mergedFileNames(record, mergeAll) {
+			exclude = append(exclude, filepath.Clean(fileName))
+		}
+		for _, row := range record {
+			exclude = append(exclude, row[0]+"-"+row[1]+"-"+"Assessment.csv", row[0]+"-"+row[1]+"-"+"ValidatedAssessment.csv")
+		}
+		sched.bank = loadQuestionBank(logger, questionBank, exclude)
+	}
+	sched.avoidTokens = avoidTokens
+	sched.run(ctx, record)
 
-			for recordIteration := range record {
-				dataInput = nil
-				dataInput = append(dataInput, profList[idx])
-				dataInput = append(dataInput, complexityList[cidx])
-				dataInput = append(dataInput, record[recordIteration][0])
-				dataInput = append(dataInput, record[recordIteration][1])
+	stopProgress()
+	<-progressDone
 
-				chanInputs <- dataInput
-			}
+	var probeModels []string
+	for _, llmName := range strings.Split(calibrationModels, ",") {
+		if llmName = strings.TrimSpace(llmName); llmName != "" {
+			probeModels = append(probeModels, llmName)
 		}
-
 	}
+	sched.calibrate(ctx, probeModels, calibrationRelabel)
 
-	close(chanInputs)
-	for i := 0; i < goRoutineCount; i++ {
-		<-tracker
+	if dedupThreshold > 0 {
+		sched.removeNearDuplicates(dedupThreshold)
 	}
-	close(geminiResponse)
-
-	<-tracker
 
-	if debug {
-		fmt.Println("----------------------------------------------------")
-		fmt.Println("promptforValidationList:", len(promptforValidationList))
-		fmt.Println("----------------------------------------------------")
+	if sources != nil {
+		sched.verifyCitations(ctx, citationModel, citationMinOverlap)
 	}
 
-	return resultsMap, promptforValidationList
-
-}
-
-func main() {
-
-	var debug bool = false
-
-	// var sleepSeconds int = 60
-
-	var assessmentfileName string
-	var validatedAssessmentfileName string
-	var dataRecord [][]string
-	var mergedFileName string
-
-	ctx := context.Background()
-
-	csvfile, err := os.Open("TopicsforAssessmentGeneration.csv")
-	if err != nil {
-		log.Fatalln("Couldn't open the csv file", err)
+	if judgeModel != "" {
+		sched.judge(ctx, judgeModel)
 	}
-	defer csvfile.Close()
+	logger.Info("generation and validation done", "completed_topics", len(sched.completedTopics),
+		"interrupted_topics", len(sched.interruptedTopics), "budget_limited_topics", len(sched.budgetLimitedTopics))
 
-	// Parse the file
-	r := csv.NewReader(csvfile)
+	mergeFiles(logger, record, mergeAll)
 
-	record, _ := r.ReadAll()
+	failures.report(filepath.Join(runDirectory(runID), "FailedCells.csv"))
 
-	for recordIteration := range record {
-		dataRecord = nil
+	svc.safetyLog.report(filepath.Join(runDirectory(runID), "SafetyReport.csv"))
 
-		mergedFileName = record[recordIteration][0]
+	svc.ledger.report(filepath.Join(runDirectory(runID), "CostReport.json"))
 
-		dataRecord = append(dataRecord, record[recordIteration])
+	svc.budget.report(filepath.Join(runDirectory(runID), "BudgetReport.json"))
 
-		assessmentfileName = record[recordIteration][0] + "-" + record[recordIteration][1] + "-" + "Assessment.csv"
-		validatedAssessmentfileName = record[recordIteration][0] + "-" + record[recordIteration][1] + "-" + "ValidatedAssessment.csv"
+	sched.reportRevisions(filepath.Join(runDirectory(runID), "Revisions.json"))
 
-		fmt.Println("Generating Assessments Started")
-		resultsMap, promptforValidationList := generateAssessments(ctx, debug, 4, dataRecord)
-		fmt.Println("Generating Assessments Done")
+	sched.reportObjectives(objectives, filepath.Join(runDirectory(runID), "ObjectiveCoverage.csv"))
 
-		// time.Sleep(60 * time.Second)
+	buildQualityReport(runID, sched, &failures, svc.ledger, svc.budget).write(
+		filepath.Join(runDirectory(runID), "QualityReport.json"), filepath.Join(runDirectory(runID), "QualityReport.html"))
 
-		fmt.Println("Flushing Assessments Started")
-		csvWriteStringFile(resultsMap, assessmentfileName)
-		fmt.Println("Flushing Assessments Done")
-
-		// time.Sleep(60 * time.Second)
-
-		fmt.Println("Validating Assessments Started")
-		allValidatedResultsMap := validateAsessments(ctx, debug, 4, promptforValidationList)
-		fmt.Println("Validating Assessments Done")
-
-		// time.Sleep(60 * time.Second)
-
-		fmt.Println("Updating Maps Started")
-		resultsMap, mismatchedDataString := updateMaps(debug, resultsMap, allValidatedResultsMap)
-		fmt.Println("Updating Maps Done")
-
-		// time.Sleep(60 * time.Second)
-
-		fmt.Println("Round 1 Stats")
-		fmt.Println("----------------------------------------------------")
-		fmt.Println("Len of Validated List :", len(allValidatedResultsMap), "Len of Map  :", len(resultsMap), " Mismatched :", len(mismatchedDataString))
-		fmt.Println("----------------------------------------------------")
-		fmt.Println("Round 1 Stats")
-
-		csvWriteStringFile(resultsMap, validatedAssessmentfileName)
+	if ctx.Err() != nil {
+		if err := writePartialMarker(runID, sched.completedTopics, sched.interruptedTopics); err != nil {
+			logger.Error("partial run marker not written", "err", err)
+		}
+		logger.Warn("run interrupted, partial results flushed", "resume_with", "-run-id "+runID)
+		client.Close()
+		journal.Close()
+		os.Exit(exitInterrupted)
 	}
-
-	mergeFiles(mergedFileName + "-" + "Validated.csv")
+	clearPartialMarker(runID)
 
 }
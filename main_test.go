@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestGetAllResponseMap(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty list", text: "[]", want: 0},
+		{name: "null", text: "null", want: 0},
+		{name: "unparseable", text: `[{"Question": "cut`, want: 0},
+		{name: "two questions", text: `[{"Question": "Q1", "Proficiency": "Learner"}, {"Question": "Q2"}]`, want: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{
+				{Content: &genai.Content{Parts: []genai.Part{genai.Text(test.text)}}},
+			}}
+			if got := getAllResponseMap(logger, resp); len(got) != test.want {
+				t.Errorf("getAllResponseMap(%s) = %d questions, want %d", test.text, len(got), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runMetrics are exported on /metrics when a metrics address is given. A nil
+// *runMetrics records nothing.
+type runMetrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	retries   *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	tokens    *prometheus.CounterVec
+	questions *prometheus.CounterVec
+}
+
+func newRunMetrics() *runMetrics {
+
+	m := &runMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "assessment",
+			Name:      "llm_requests_total",
+			Help:      "LLM calls made, counting every attempt.",
+		}, []string{"model", "phase", "subject"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "assessment",
+			Name:      "llm_failures_total",
+			Help:      "Failed LLM attempts by error class.",
+		}, []string{"model", "phase", "subject", "class"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "assessment",
+			Name:      "llm_retries_total",
+			Help:      "LLM attempts that were retried.",
+		}, []string{"model", "phase", "subject"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "assessment",
+			Name:      "llm_request_duration_seconds",
+			Help:      "Latency of a single LLM attempt.",
+			Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+		}, []string{"model", "phase"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "assessment",
+			Name:      "llm_tokens_total",
+			Help:      "Tokens used, by kind (prompt or candidate).",
+		}, []string{"model", "phase", "subject", "kind"}),
+		questions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "assessment",
+			Name:      "questions_total",
+			Help:      "Questions by outcome: generated, lint_rejected, validated, mismatched or rejected.",
+		}, []string{"phase", "subject", "outcome"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.failures, m.retries, m.latency, m.tokens, m.questions)
+
+	return m
+}
+
+func (m *runMetrics) observeAttempt(call llmCall, latency time.Duration, promptTokens int32, candidateTokens int32) {
+
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(call.LLMName, call.Phase, call.Subject).Inc()
+	m.latency.WithLabelValues(call.LLMName, call.Phase).Observe(latency.Seconds())
+	m.tokens.WithLabelValues(call.LLMName, call.Phase, call.Subject, "prompt").Add(float64(promptTokens))
+	m.tokens.WithLabelValues(call.LLMName, call.Phase, call.Subject, "candidate").Add(float64(candidateTokens))
+}
+
+func (m *runMetrics) observeFailure(call llmCall, class errorClass, retried bool) {
+
+	if m == nil {
+		return
+	}
+
+	m.failures.WithLabelValues(call.LLMName, call.Phase, call.Subject, class.String()).Inc()
+	if retried {
+		m.retries.WithLabelValues(call.LLMName, call.Phase, call.Subject).Inc()
+	}
+}
+
+const (
+	outcomeGenerated  = "generated"
+	outcomeValidated  = "validated"
+	outcomeMismatched = "mismatched"
+	outcomeRejected   = "rejected"
+
+	outcomeLintRejected = "lint_rejected"
+)
+
+func (m *runMetrics) countQuestions(phase string, subject string, outcome string, count int) {
+	if m == nil || count == 0 {
+		return
+	}
+	m.questions.WithLabelValues(phase, subject, outcome).Add(float64(count))
+}
+
+// serve exposes /metrics on addr until ctx is done.
+func (m *runMetrics) serve(ctx context.Context, addr string) {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics endpoint stopped", "addr", addr, "err", err)
+		}
+	}()
+
+	slog.Info("metrics endpoint listening", "addr", addr, "path", "/metrics")
+}
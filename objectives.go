@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var bloomLevels = []string{"Remember", "Understand", "Apply", "Analyze", "Evaluate", "Create"}
+
+// learningObjective is one row of the objectives file.
+type learningObjective struct {
+	ID          string
+	Description string
+}
+
+// loadObjectives reads the learning objectives of every topic from a CSV file
+// with the rows Subject,Topic,ObjectiveID,Objective. The objectives are keyed
+// by Subject-Topic, where Topic may name a sub-topic as "Topic - SubTopic".
+func loadObjectives(fileName string) (map[string][]learningObjective, error) {
+
+	csvfile, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer csvfile.Close()
+
+	r := csv.NewReader(csvfile)
+	r.FieldsPerRecord = -1
+	record, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("objectives file %s: %w", fileName, err)
+	}
+
+	objectives := make(map[string][]learningObjective)
+	for idx, row := range record {
+		if idx == 0 && len(row) > 2 && strings.EqualFold(strings.TrimSpace(row[2]), "ObjectiveID") {
+			continue
+		}
+		if len(row) < 3 || strings.TrimSpace(row[2]) == "" {
+			return nil, fmt.Errorf("objectives file %s line %d: expected Subject,Topic,ObjectiveID,Objective", fileName, idx+1)
+		}
+		objective := learningObjective{ID: strings.TrimSpace(row[2])}
+		if len(row) > 3 {
+			objective.Description = strings.TrimSpace(row[3])
+		}
+		key := strings.TrimSpace(row[0]) + "-" + strings.TrimSpace(row[1])
+		objectives[key] = append(objectives[key], objective)
+	}
+
+	return objectives, nil
+}
+
+// withObjectives appends the learning objectives of the topic to a generation
+// prompt and asks for the IDs each question assesses.
+func withObjectives(prompt string, objectives []learningObjective) string {
+
+	if len(objectives) == 0 {
+		return prompt
+	}
+
+	var list []string
+	for _, objective := range objectives {
+		list = append(list, objective.ID+": "+objective.Description)
+	}
+
+	return prompt + `
+
+	The Questions should assess the following learning objectives of the Topic, each given as ID: objective
+	- ` + strings.Join(list, "\n\t- ") + `
+	For each Question generated, add the IDs of the learning objectives it assesses to the Assessment as 'Objectives': []`
+}
+
+// tagAssessments brings the Bloom level of every question to its canonical
+// name and drops objective IDs that the topic does not define. It returns
+// how many IDs were dropped.
+func tagAssessments(assessments map[string]assessmentDataforMap, objectives []learningObjective) int {
+
+	dropped := 0
+	for question, v := range assessments {
+		for _, level := range bloomLevels {
+			if strings.EqualFold(strings.TrimSpace(v.BloomLevel), level) ||
+				strings.EqualFold(strings.TrimSpace(v.BloomLevel), strings.Replace(level, "yze", "yse", 1)) {
+				v.BloomLevel = level
+			}
+		}
+
+		var known []string
+		for _, id := range v.Objectives {
+			id = strings.TrimSpace(id)
+			if slices.ContainsFunc(objectives, func(o learningObjective) bool { return o.ID == id }) && !slices.Contains(known, id) {
+				known = append(known, id)
+			} else {
+				dropped++
+			}
+		}
+		v.Objectives = known
+
+		assessments[question] = v
+	}
+
+	return dropped
+}
+
+// reportObjectives prints how many learning objectives of each topic the kept
+// questions cover per proficiency, with the Bloom levels per proficiency, and
+// persists the coverage of every objective.
+func (s *scheduler) reportObjectives(objectives map[string][]learningObjective, fileName string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bloom := make(map[string]map[string]int)
+	questions := 0
+	for _, name := range s.topicOrder {
+		for _, v := range s.topics[name].Final {
+			if bloom[v.Proficiency] == nil {
+				bloom[v.Proficiency] = make(map[string]int)
+			}
+			bloom[v.Proficiency][v.BloomLevel]++
+			questions++
+		}
+	}
+	if questions == 0 {
+		return
+	}
+
+	fmt.Println("Objective Coverage")
+	fmt.Println("----------------------------------------------------")
+
+	var rows []string
+	sep := ";"
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		topicObjectives := objectives[name]
+		if len(topicObjectives) == 0 {
+			continue
+		}
+
+		for _, proficiency := range profList {
+			covered := make(map[string]int)
+			for _, v := range topic.Final {
+				if strings.EqualFold(v.Proficiency, proficiency) {
+					for _, id := range v.Objectives {
+						covered[id]++
+					}
+				}
+			}
+
+			var uncovered []string
+			for _, objective := range topicObjectives {
+				if covered[objective.ID] == 0 {
+					uncovered = append(uncovered, objective.ID)
+				}
+				rows = append(rows, topic.Record[0]+sep+topic.Record[1]+sep+proficiency+sep+objective.ID+sep+
+					strings.ReplaceAll(objective.Description, sep, ",")+sep+strconv.Itoa(covered[objective.ID]))
+			}
+			fmt.Printf("%s %-12s covered %d/%d  uncovered %s\n", name, proficiency,
+				len(topicObjectives)-len(uncovered), len(topicObjectives), strings.Join(uncovered, ", "))
+		}
+	}
+	fmt.Println("----------------------------------------------------")
+
+	for _, proficiency := range profList {
+		line := fmt.Sprintf("%-12s", proficiency)
+		for _, level := range bloomLevels {
+			line += fmt.Sprintf("  %s %d", level, bloom[proficiency][level])
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("----------------------------------------------------")
+
+	if len(rows) == 0 {
+		return
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		slog.Error("objective coverage not written", "file", fileName, "err", err)
+		return
+	}
+	defer file.Close()
+
+	file.WriteString("Subject" + sep + "Topic" + sep + "Proficiency" + sep + "ObjectiveID" + sep + "Objective" + sep + "Questions" + "\n")
+	for _, row := range rows {
+		file.WriteString(row + "\n")
+	}
+
+	file.Sync()
+}
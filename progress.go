@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressTracker counts settled generation cells and validation batches for
+// the progress view. Cells restored from the journal count as done but are
+// left out of the rate the ETA is based on. A nil *progressTracker records
+// nothing.
+type progressTracker struct {
+	// sharedTerminal is set when the status line and the logs go to the same
+	// terminal, where every log line would break the status line up
+	sharedTerminal bool
+
+	mu            sync.Mutex
+	start         time.Time
+	totalCells    int
+	restoredCells int
+	doneCells     int
+	failedCells   int
+	queuedBatches int
+	doneBatches   int
+	restoredWork  int
+	errors        int
+	retries       int
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{start: time.Now(), sharedTerminal: isTerminal(os.Stdout) && isTerminal(os.Stderr)}
+}
+
+// completionLevel is the level of the per cell and per batch completion logs:
+// debug while the status line shows the same progress on the terminal.
+func (p *progressTracker) completionLevel() slog.Level {
+	if p != nil && p.sharedTerminal {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+type progressSnapshot struct {
+	TotalCells    int
+	DoneCells     int
+	QueuedBatches int
+	DoneBatches   int
+	Errors        int
+	Retries       int
+	Elapsed       time.Duration
+	ETA           time.Duration
+}
+
+// restored sets the totals once the journal has been replayed. Every cell
+// that is not given up on yields one validation batch, so the work left is
+// two units per pending cell and one per pending batch.
+func (p *progressTracker) restored(totalCells int, restoredCells int, restoredBatches int, pendingBatches int) {
+
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.totalCells = totalCells
+	p.restoredCells = restoredCells
+	p.doneCells = restoredCells
+	p.queuedBatches = restoredBatches + pendingBatches
+	p.doneBatches = restoredBatches
+	p.restoredWork = restoredCells + restoredBatches
+	p.start = time.Now()
+}
+
+// cellDone settles a generation cell; batched tells whether it produced a
+// validation batch, otherwise it failed or was skipped.
+func (p *progressTracker) cellDone(batched bool) {
+
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.doneCells++
+	if batched {
+		p.queuedBatches++
+	} else {
+		p.failedCells++
+	}
+}
+
+func (p *progressTracker) batchDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.doneBatches++
+	p.mu.Unlock()
+}
+
+func (p *progressTracker) observeFailure(retried bool) {
+
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.errors++
+	if retried {
+		p.retries++
+	}
+}
+
+func (p *progressTracker) snapshot() progressSnapshot {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := progressSnapshot{
+		TotalCells:    p.totalCells,
+		DoneCells:     p.doneCells,
+		QueuedBatches: p.queuedBatches,
+		DoneBatches:   p.doneBatches,
+		Errors:        p.errors,
+		Retries:       p.retries,
+		Elapsed:       time.Since(p.start),
+	}
+
+	totalWork := 2*p.totalCells - p.failedCells
+	doneWork := p.doneCells + p.doneBatches
+	sessionWork := doneWork - p.restoredWork
+	if sessionWork > 0 && doneWork < totalWork {
+		perUnit := snapshot.Elapsed / time.Duration(sessionWork)
+		snapshot.ETA = perUnit * time.Duration(totalWork-doneWork)
+	}
+
+	return snapshot
+}
+
+func (s progressSnapshot) eta() string {
+	if s.ETA == 0 && s.DoneCells < s.TotalCells {
+		return "--"
+	}
+	return s.ETA.Round(time.Second).String()
+}
+
+// isTerminal tells whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// display redraws a single status line on stdout while it is a terminal and
+// otherwise logs the progress every interval. It returns when ctx is done,
+// after showing the final state.
+func (p *progressTracker) display(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+
+	if p == nil {
+		return
+	}
+
+	tty := isTerminal(os.Stdout)
+	if tty {
+		interval = 500 * time.Millisecond
+	}
+
+	show := func() {
+		s := p.snapshot()
+		if tty {
+			fmt.Printf("\r\033[KCells %d/%d  Batches %d/%d  Errors %d  Retries %d  Elapsed %s  ETA %s",
+				s.DoneCells, s.TotalCells, s.DoneBatches, s.QueuedBatches, s.Errors, s.Retries,
+				s.Elapsed.Round(time.Second), s.eta())
+			return
+		}
+		logger.Info("progress", "cells_done", s.DoneCells, "cells_total", s.TotalCells, "batches_done", s.DoneBatches,
+			"batches_queued", s.QueuedBatches, "errors", s.Errors, "retries", s.Retries,
+			"elapsed", s.Elapsed.Round(time.Second), "eta", s.eta())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			show()
+		case <-ctx.Done():
+			show()
+			if tty {
+				fmt.Println()
+			}
+			return
+		}
+	}
+}
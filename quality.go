@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Verdicts of the validator on one question.
+const (
+	verdictAgreed      = "agreed"
+	verdictMismatched  = "mismatched"
+	verdictDoNotKnow   = "do not know"
+	verdictNotListed   = "not listed"
+	verdictUnvalidated = "unvalidated"
+)
+
+// validationVerdict compares the validated answer with the answer key,
+// recognising the two escape options offered by getPromptRefinedforValidation.
+func validationVerdict(v assessmentDataforMap) string {
+
+	answer := strings.ToLower(strings.TrimSpace(v.ValidatedAnswer))
+
+	switch {
+	case answer == "":
+		return verdictUnvalidated
+	case strings.HasPrefix(answer, "i do not know"):
+		return verdictDoNotKnow
+	case strings.Contains(answer, "right option is not listed"):
+		return verdictNotListed
+	case v.ValidatedAnswer == v.Answer:
+		return verdictAgreed
+	default:
+		return verdictMismatched
+	}
+}
+
+type qualityCounts struct {
+	Questions     int
+	Validated     int
+	Agreed        int
+	Mismatched    int
+	DoNotKnow     int
+	NotListed     int
+	Unvalidated   int
+	Judged        int
+	JudgeScore    float64
+	AgreementRate float64
+	DoNotKnowRate float64
+	NotListedRate float64
+}
+
+func (c *qualityCounts) count(v assessmentDataforMap) {
+
+	c.Questions++
+	if v.JudgeScore > 0 {
+		c.Judged++
+		c.JudgeScore += v.JudgeScore
+	}
+
+	switch validationVerdict(v) {
+	case verdictUnvalidated:
+		c.Unvalidated++
+		return
+	case verdictAgreed:
+		c.Agreed++
+	case verdictMismatched:
+		c.Mismatched++
+	case verdictDoNotKnow:
+		c.DoNotKnow++
+	case verdictNotListed:
+		c.NotListed++
+	}
+	c.Validated++
+}
+
+// rates are taken over the validated questions; JudgeScore becomes the mean
+// over the judged ones.
+func (c *qualityCounts) rates() {
+	if c.Judged > 0 {
+		c.JudgeScore /= float64(c.Judged)
+	}
+	if c.Validated == 0 {
+		return
+	}
+	c.AgreementRate = float64(c.Agreed) / float64(c.Validated)
+	c.DoNotKnowRate = float64(c.DoNotKnow) / float64(c.Validated)
+	c.NotListedRate = float64(c.NotListed) / float64(c.Validated)
+}
+
+// qualityGroup is one Subject/Topic, or one Subject/Topic/Proficiency/Complexity
+// cell when Proficiency and Complexity are set.
+type qualityGroup struct {
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	qualityCounts
+}
+
+type phaseLatency struct {
+	Phase          string
+	LLMName        string
+	Calls          int
+	AverageLatency time.Duration
+}
+
+// qualityReport is written per run. DuplicateRate is the share of generated
+// questions dropped as exact or near duplicates, to compare runs with and
+// without the avoid list in the generation prompts.
+type qualityReport struct {
+	RunID             string
+	CreatedAt         time.Time
+	Totals            qualityCounts
+	ByTopic           []qualityGroup
+	ByCell            []qualityGroup
+	Rejects           map[string]int
+	Generated         int
+	DuplicatesRemoved int
+	DuplicateRate     float64
+	Revised           int
+	Calibration       calibrationSummary
+	Citations         citationSummary
+	Cost              tokenUsage
+	CostByTopic       []tokenUsage
+	Latency           []phaseLatency
+}
+
+// buildQualityReport summarises the finalized topics of a run together with
+// what the failure log, the budget guard and the usage ledger recorded.
+func buildQualityReport(runID string, s *scheduler, failures *failureLog, ledger *usageLedger, budget *budgetGuard) qualityReport {
+
+	report := qualityReport{RunID: runID, CreatedAt: time.Now(), Rejects: make(map[string]int)}
+
+	s.mu.Lock()
+	report.Calibration = s.calibration
+	report.Citations = s.citations
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		topicGroup := qualityGroup{Subject: topic.Record[0], Topic: topic.Record[1]}
+		cells := make(map[string]*qualityGroup)
+
+		for _, v := range topic.Final {
+			report.Totals.count(v)
+			topicGroup.count(v)
+
+			key := v.Proficiency + "|" + v.Complexity
+			if _, ok := cells[key]; !ok {
+				cells[key] = &qualityGroup{Subject: topicGroup.Subject, Topic: topicGroup.Topic, Proficiency: v.Proficiency,
+					Complexity: v.Complexity}
+			}
+			cells[key].count(v)
+
+			switch verdict := validationVerdict(v); verdict {
+			case verdictDoNotKnow, verdictNotListed:
+				report.Rejects["validator: "+verdict]++
+			}
+		}
+
+		for _, v := range topic.Rejected {
+			for _, finding := range lintAssessment(v, s.svc.lint) {
+				if finding.Severity == lintError {
+					report.Rejects["lint: "+finding.Rule]++
+				}
+			}
+		}
+		for phase, count := range topic.Unparseable {
+			report.Rejects[phase+": unparseable response"] += count
+		}
+		report.Generated += topic.Generated
+		report.Revised += len(topic.Revisions)
+		report.DuplicatesRemoved += topic.Duplicates + topic.NearDuplicates
+		if topic.NearDuplicates > 0 {
+			report.Rejects["near duplicate"] += topic.NearDuplicates
+		}
+
+		topicGroup.rates()
+		report.ByTopic = append(report.ByTopic, topicGroup)
+
+		var cellGroups []qualityGroup
+		for _, cell := range cells {
+			cell.rates()
+			cellGroups = append(cellGroups, *cell)
+		}
+		slices.SortFunc(cellGroups, func(a, b qualityGroup) int {
+			if c := slices.Index(profList, a.Proficiency) - slices.Index(profList, b.Proficiency); c != 0 {
+				return c
+			}
+			return slices.Index(complexityList, a.Complexity) - slices.Index(complexityList, b.Complexity)
+		})
+		report.ByCell = append(report.ByCell, cellGroups...)
+	}
+	s.mu.Unlock()
+
+	report.Totals.rates()
+	if report.Generated > 0 {
+		report.DuplicateRate = float64(report.DuplicatesRemoved) / float64(report.Generated)
+	}
+
+	for _, cell := range failures.all() {
+		report.Rejects[cell.Phase+" failed: "+cell.Class.String()]++
+	}
+	for _, cell := range budget.skippedCells() {
+		report.Rejects[cell.Phase+": skipped by budget"]++
+	}
+
+	costReport := ledger.buildReport()
+	report.Cost = costReport.Totals
+	report.CostByTopic = costReport.ByTopic
+	for _, usage := range costReport.ByPhase {
+		latency := phaseLatency{Phase: usage.Phase, LLMName: usage.LLMName, Calls: usage.Calls}
+		if usage.Calls > 0 {
+			latency.AverageLatency = usage.Latency / time.Duration(usage.Calls)
+		}
+		report.Latency = append(report.Latency, latency)
+	}
+
+	return report
+}
+
+var qualityReportPage = template.Must(template.New("quality").Funcs(template.FuncMap{
+	"percent": func(rate float64) string { return fmt.Sprintf("%.1f%%", rate*100) },
+	"usd":     func(cost float64) string { return fmt.Sprintf("$%.4f", cost) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Quality Report {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th { background: #f0f0f0; }
+td.name { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Quality Report</h1>
+<p>Run {{.RunID}}, {{.CreatedAt.Format "2006-01-02 15:04:05"}}</p>
+
+<h2>Totals</h2>
+<table>
+<tr><th>Questions</th><th>Validated</th><th>Agreement</th><th>I do not know</th><th>Not listed</th><th>Revised</th><th>Duplicates removed</th><th>Duplicate rate</th><th>Cost</th></tr>
+<tr><td>{{.Totals.Questions}}</td><td>{{.Totals.Validated}}</td><td>{{percent .Totals.AgreementRate}}</td>
+<td>{{percent .Totals.DoNotKnowRate}}</td><td>{{percent .Totals.NotListedRate}}</td><td>{{.Revised}}</td><td>{{.DuplicatesRemoved}}</td><td>{{percent .DuplicateRate}}</td><td>{{usd .Cost.Cost}}</td></tr>
+</table>
+
+<h2>By Topic</h2>
+<table>
+<tr><th>Subject</th><th>Topic</th><th>Questions</th><th>Validated</th><th>Agreed</th><th>Mismatched</th><th>Agreement</th><th>I do not know</th><th>Not listed</th><th>Judge score</th></tr>
+{{range .ByTopic}}<tr><td class="name">{{.Subject}}</td><td class="name">{{.Topic}}</td><td>{{.Questions}}</td><td>{{.Validated}}</td><td>{{.Agreed}}</td>
+<td>{{.Mismatched}}</td><td>{{percent .AgreementRate}}</td><td>{{percent .DoNotKnowRate}}</td><td>{{percent .NotListedRate}}</td>
+<td>{{if .Judged}}{{printf "%.2f" .JudgeScore}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>By Proficiency and Complexity</h2>
+<table>
+<tr><th>Subject</th><th>Topic</th><th>Proficiency</th><th>Complexity</th><th>Questions</th><th>Validated</th><th>Agreement</th><th>I do not know</th><th>Not listed</th></tr>
+{{range .ByCell}}<tr><td class="name">{{.Subject}}</td><td class="name">{{.Topic}}</td><td class="name">{{.Proficiency}}</td><td class="name">{{.Complexity}}</td>
+<td>{{.Questions}}</td><td>{{.Validated}}</td><td>{{percent .AgreementRate}}</td><td>{{percent .DoNotKnowRate}}</td><td>{{percent .NotListedRate}}</td></tr>
+{{end}}</table>
+
+<h2>Complexity Calibration</h2>
+<table>
+<tr><th>Probe models</th><th>Labels off the requested cell</th><th>Contradicted by probes</th><th>Relabeled</th></tr>
+<tr><td class="name">{{range .Calibration.ProbeModels}}{{.}} {{else}}validator only{{end}}</td><td>{{.Calibration.LabelMismatches}}</td>
+<td>{{.Calibration.Flagged}}</td><td>{{.Calibration.Relabeled}}</td></tr>
+</table>
+{{if or .Citations.Checked .Citations.Fabricated}}
+<h2>Citations</h2>
+<table>
+<tr><th>Checked</th><th>Supported</th><th>Unsupported</th><th>Contradicted</th><th>Missing or fabricated</th><th>Overlap and model disagree</th>
+<th>Model unchecked</th></tr>
+<tr><td>{{.Citations.Checked}}</td><td>{{.Citations.Supported}}</td><td>{{.Citations.Unsupported}}</td>
+<td>{{.Citations.Contradicted}}</td><td>{{.Citations.Fabricated}}</td><td>{{.Citations.Disagreements}}</td>
+<td>{{.Citations.Unchecked}}</td></tr>
+</table>
+{{end}}
+
+<h2>Rejects</h2>
+<table>
+<tr><th>Reason</th><th>Count</th></tr>
+{{range $reason, $count := .Rejects}}<tr><td class="name">{{$reason}}</td><td>{{$count}}</td></tr>
+{{else}}<tr><td class="name" colspan="2">None</td></tr>
+{{end}}</table>
+
+<h2>Cost and Latency</h2>
+<table>
+<tr><th>Phase</th><th>Model</th><th>Calls</th><th>Average latency</th></tr>
+{{range .Latency}}<tr><td class="name">{{.Phase}}</td><td class="name">{{.LLMName}}</td><td>{{.Calls}}</td><td>{{.AverageLatency}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Subject</th><th>Topic</th><th>Calls</th><th>Tokens</th><th>Cost</th></tr>
+{{range .CostByTopic}}<tr><td class="name">{{.Subject}}</td><td class="name">{{.Topic}}</td><td>{{.Calls}}</td>
+<td>{{.PromptTokens}} + {{.CandidateTokens}}</td><td>{{usd .Cost}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// write prints the headline numbers and persists the report as JSON and as
+// a self-contained HTML page.
+func (r qualityReport) write(jsonFileName string, htmlFileName string) {
+
+	fmt.Println("Quality Report")
+	fmt.Println("----------------------------------------------------")
+	fmt.Printf("Questions %d  validated %d  agreement %.1f%%  do not know %.1f%%  not listed %.1f%%\n",
+		r.Totals.Questions, r.Totals.Validated, r.Totals.AgreementRate*100, r.Totals.DoNotKnowRate*100,
+		r.Totals.NotListedRate*100)
+	fmt.Printf("Generated %d  revised %d  duplicates removed %d  duplicate rate %.1f%%\n", r.Generated, r.Revised,
+		r.DuplicatesRemoved, r.DuplicateRate*100)
+	fmt.Printf("Complexity labels off the requested cell %d  contradicted by probes %d  relabeled %d\n",
+		r.Calibration.LabelMismatches, r.Calibration.Flagged, r.Calibration.Relabeled)
+	if r.Citations.Checked+r.Citations.Fabricated > 0 {
+		fmt.Printf("Citations checked %d  supported %d  unsupported %d  contradicted %d  missing or fabricated %d  disagreements %d  "+
+			"model unchecked %d\n", r.Citations.Checked, r.Citations.Supported, r.Citations.Unsupported, r.Citations.Contradicted,
+			r.Citations.Fabricated, r.Citations.Disagreements, r.Citations.Unchecked)
+	}
+	fmt.Println("----------------------------------------------------")
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		slog.Error("quality report not written", "file", jsonFileName, "err", err)
+		return
+	}
+	if err := os.WriteFile(jsonFileName, content, 0o644); err != nil {
+		slog.Error("quality report not written", "file", jsonFileName, "err", err)
+	}
+
+	page, err := os.Create(htmlFileName)
+	if err != nil {
+		slog.Error("quality report not written", "file", htmlFileName, "err", err)
+		return
+	}
+	defer page.Close()
+
+	if err := qualityReportPage.Execute(page, r); err != nil {
+		slog.Error("quality report not written", "file", htmlFileName, "err", err)
+	}
+}
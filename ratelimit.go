@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConcurrency is the number of calls in flight per model when the
+// model has no explicit limit.
+const defaultConcurrency int = 4
+
+// expectedOutputTokens is added to the prompt estimate when reserving tokens
+// per minute ahead of a call; the difference is settled once usage is known.
+const expectedOutputTokens int = 1024
+
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	perSec   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute float64) *tokenBucket {
+	return &tokenBucket{
+		capacity: perMinute,
+		tokens:   perMinute,
+		perSec:   perMinute / 60.0,
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSec
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// wait blocks until n tokens are available and takes them. Requests larger
+// than the bucket only wait for a full bucket so they cannot block forever.
+func (b *tokenBucket) wait(ctx context.Context, n float64) error {
+
+	if n > b.capacity {
+		n = b.capacity
+	}
+
+	for {
+		b.mu.Lock()
+		b.refill(time.Now())
+		if b.tokens >= n {
+			b.tokens -= n
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((n - b.tokens) / b.perSec * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// adjust returns (n > 0) or takes (n < 0) tokens without waiting.
+func (b *tokenBucket) adjust(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens += n
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+type modelLimit struct {
+	RequestsPerMinute float64
+	TokensPerMinute   float64
+	MaxInFlight       int
+}
+
+type modelLimiter struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+	slots    chan empty
+}
+
+// wait reserves one request and the estimated tokens for a call.
+func (l *modelLimiter) wait(ctx context.Context, estimatedTokens int) error {
+
+	if l == nil {
+		return nil
+	}
+
+	if l.requests != nil {
+		if err := l.requests.wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+
+	if l.tokens != nil {
+		if err := l.tokens.wait(ctx, float64(estimatedTokens)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// acquire takes one of the model's in-flight slots.
+func (l *modelLimiter) acquire(ctx context.Context) error {
+
+	if l == nil || l.slots == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- empty{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *modelLimiter) release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// settle corrects the token reservation once the actual usage is known.
+func (l *modelLimiter) settle(estimatedTokens int, actualTokens int) {
+	if l == nil || l.tokens == nil || actualTokens <= 0 {
+		return
+	}
+	l.tokens.adjust(float64(estimatedTokens - actualTokens))
+}
+
+var defaultModelLimits = map[string]modelLimit{
+	"gemini-1.5-flash":    {RequestsPerMinute: 15, TokensPerMinute: 1000000, MaxInFlight: defaultConcurrency},
+	"gemini-1.5-flash-8b": {RequestsPerMinute: 15, TokensPerMinute: 1000000, MaxInFlight: defaultConcurrency},
+	"gemini-1.5-pro":      {RequestsPerMinute: 2, TokensPerMinute: 32000, MaxInFlight: 2},
+}
+
+// rateLimiters hands out one limiter per model so that every worker pool
+// calling the same model draws from the same buckets.
+type rateLimiters struct {
+	mu       sync.Mutex
+	limits   map[string]modelLimit
+	limiters map[string]*modelLimiter
+}
+
+func newRateLimiters(limits map[string]modelLimit) *rateLimiters {
+	return &rateLimiters{
+		limits:   limits,
+		limiters: make(map[string]*modelLimiter),
+	}
+}
+
+func (r *rateLimiters) forModel(llmName string) *modelLimiter {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limiter, ok := r.limiters[llmName]; ok {
+		return limiter
+	}
+
+	limit, ok := r.limits[llmName]
+	if !ok {
+		return nil
+	}
+
+	limiter := &modelLimiter{}
+	if limit.RequestsPerMinute > 0 {
+		limiter.requests = newTokenBucket(limit.RequestsPerMinute)
+	}
+	if limit.TokensPerMinute > 0 {
+		limiter.tokens = newTokenBucket(limit.TokensPerMinute)
+	}
+	if limit.MaxInFlight > 0 {
+		limiter.slots = make(chan empty, limit.MaxInFlight)
+	}
+	r.limiters[llmName] = limiter
+
+	return limiter
+}
+
+// concurrency is the number of calls the model may have in flight across
+// every stage that uses it.
+func (r *rateLimiters) concurrency(llmName string) int {
+
+	if r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if limit, ok := r.limits[llmName]; ok && limit.MaxInFlight > 0 {
+			return limit.MaxInFlight
+		}
+	}
+
+	return defaultConcurrency
+}
+
+// parseModelLimits reads limits in the form
+// "gemini-1.5-flash=15:1000000,gemini-1.5-flash-8b=15:1000000"
+// where each value is requests per minute and tokens per minute; 0 disables
+// that limit.
+func parseModelLimits(value string, limits map[string]modelLimit) error {
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		llmName, rates, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("rate limit %q: expected model=rpm:tpm", entry)
+		}
+
+		rpmValue, tpmValue, _ := strings.Cut(rates, ":")
+
+		var limit modelLimit
+		var err error
+		if limit.RequestsPerMinute, err = strconv.ParseFloat(rpmValue, 64); err != nil {
+			return fmt.Errorf("rate limit %q: %w", entry, err)
+		}
+		if tpmValue != "" {
+			if limit.TokensPerMinute, err = strconv.ParseFloat(tpmValue, 64); err != nil {
+				return fmt.Errorf("rate limit %q: %w", entry, err)
+			}
+		}
+
+		llmName = strings.TrimSpace(llmName)
+		limit.MaxInFlight = limits[llmName].MaxInFlight
+		limits[llmName] = limit
+	}
+
+	return nil
+}
+
+// parseModelConcurrency reads in-flight limits in the form
+// "gemini-1.5-flash=4,gemini-1.5-flash-8b=8".
+func parseModelConcurrency(value string, limits map[string]modelLimit) error {
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		llmName, countValue, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("concurrency %q: expected model=count", entry)
+		}
+
+		count, err := strconv.Atoi(countValue)
+		if err != nil || count < 1 {
+			return fmt.Errorf("concurrency %q: expected a positive count", entry)
+		}
+
+		llmName = strings.TrimSpace(llmName)
+		limit := limits[llmName]
+		limit.MaxInFlight = count
+		limits[llmName] = limit
+	}
+
+	return nil
+}
+
+func estimateTokens(texts ...string) int {
+	total := 0
+	for _, text := range texts {
+		total += len(text) / 4
+	}
+	return total
+}
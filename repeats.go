@@ -0,0 +1,56 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// avoidList picks the question stems a new generation cell of a topic should
+// not repeat: those accepted so far in this run, then those of the question
+// bank, until maxTokens is reached. Stems are joined one per line.
+func (s *scheduler) avoidList(subject string, topic string, maxTokens int) string {
+
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	s.mu.Lock()
+	stems := slices.Sorted(maps.Keys(s.topics[subject+"-"+topic].ResultsMap))
+	s.mu.Unlock()
+
+	for _, question := range s.bank {
+		if question.Assessment.Subject == subject && question.Assessment.Topic == topic {
+			stems = append(stems, question.Assessment.Question)
+		}
+	}
+
+	var list []string
+	tokens := 0
+	for _, stem := range stems {
+		stem = strings.TrimSpace(stem)
+		if stem == "" || slices.Contains(list, stem) {
+			continue
+		}
+		tokens += estimateTokens(stem)
+		if tokens > maxTokens {
+			break
+		}
+		list = append(list, stem)
+	}
+
+	return strings.Join(list, "\n")
+}
+
+// withAvoidList appends the stems to avoid to a generation prompt.
+func withAvoidList(prompt string, avoid string) string {
+
+	if avoid == "" {
+		return prompt
+	}
+
+	return prompt + `
+
+	These Questions already exist for the Topic. Do not repeat them or ask them again in other words:
+	- ` + strings.ReplaceAll(avoid, "\n", "\n\t- ")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+type errorClass int
+
+const (
+	errorClassRetryable errorClass = iota
+	errorClassPermanent
+	errorClassCanceled
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errorClassRetryable:
+		return "retryable"
+	case errorClassPermanent:
+		return "permanent"
+	case errorClassCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
+type retryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	Multiplier     float64
+	Jitter         float64
+	RequestTimeout time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxAttempts:    5,
+	BaseDelay:      2 * time.Second,
+	MaxDelay:       60 * time.Second,
+	Multiplier:     2.0,
+	Jitter:         0.5,
+	RequestTimeout: 2 * time.Minute,
+}
+
+// backoff returns the delay before the given retry attempt (1 based), with
+// the exponential delay randomly shortened by up to Jitter of its length.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+
+	delay := float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	delay = delay - delay*p.Jitter*rand.Float64()
+
+	return time.Duration(delay)
+}
+
+type classifiedError struct {
+	Class      errorClass
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func classifyError(err error) classifiedError {
+
+	if errors.Is(err, context.Canceled) {
+		return classifiedError{Class: errorClassCanceled, Reason: "canceled"}
+	}
+
+	var blockedErr *genai.BlockedError
+	if errors.As(err, &blockedErr) {
+		return classifiedError{Class: errorClassPermanent, Reason: describeBlocked(blockedErr)}
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		var retryAfter time.Duration
+		if info := apiErr.Details().RetryInfo; info != nil {
+			retryAfter = info.GetRetryDelay().AsDuration()
+		}
+
+		if code := apiErr.HTTPCode(); code > 0 {
+			return classifyHTTPStatus(code, retryAfter)
+		}
+		if st := apiErr.GRPCStatus(); st != nil {
+			return classifyGRPCCode(st.Code(), retryAfter)
+		}
+	}
+
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		return classifyHTTPStatus(gErr.Code, parseRetryAfter(gErr.Header))
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return classifiedError{Class: errorClassRetryable, Reason: "timeout"}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return classifiedError{Class: errorClassRetryable, Reason: "timeout"}
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return classifiedError{Class: errorClassRetryable, Reason: "connection reset"}
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "api key not valid"), strings.Contains(msg, "api_key_invalid"):
+		return classifiedError{Class: errorClassPermanent, Reason: "invalid api key"}
+	case strings.Contains(msg, "resource_exhausted"), strings.Contains(msg, "429"):
+		return classifiedError{Class: errorClassRetryable, Reason: "rate limited"}
+	}
+
+	return classifiedError{Class: errorClassRetryable, Reason: "unknown error"}
+}
+
+func classifyHTTPStatus(code int, retryAfter time.Duration) classifiedError {
+
+	switch {
+	case code == http.StatusTooManyRequests:
+		return classifiedError{Class: errorClassRetryable, Reason: "rate limited", RetryAfter: retryAfter}
+	case code == http.StatusRequestTimeout:
+		return classifiedError{Class: errorClassRetryable, Reason: "timeout", RetryAfter: retryAfter}
+	case code >= 500:
+		return classifiedError{Class: errorClassRetryable, Reason: fmt.Sprintf("server error %d", code), RetryAfter: retryAfter}
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return classifiedError{Class: errorClassPermanent, Reason: "invalid api key"}
+	}
+
+	return classifiedError{Class: errorClassPermanent, Reason: fmt.Sprintf("client error %d", code)}
+}
+
+func classifyGRPCCode(code codes.Code, retryAfter time.Duration) classifiedError {
+
+	switch code {
+	case codes.ResourceExhausted:
+		return classifiedError{Class: errorClassRetryable, Reason: "rate limited", RetryAfter: retryAfter}
+	case codes.DeadlineExceeded:
+		return classifiedError{Class: errorClassRetryable, Reason: "timeout", RetryAfter: retryAfter}
+	case codes.Unavailable, codes.Internal, codes.Aborted, codes.Unknown:
+		return classifiedError{Class: errorClassRetryable, Reason: "server error " + code.String(), RetryAfter: retryAfter}
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return classifiedError{Class: errorClassPermanent, Reason: "invalid api key"}
+	case codes.Canceled:
+		return classifiedError{Class: errorClassCanceled, Reason: "canceled"}
+	}
+
+	return classifiedError{Class: errorClassPermanent, Reason: "client error " + code.String()}
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+
+	return 0
+}
+
+// llmCall is everything generateWithRetry needs to make, throttle and account
+// for a call; Phase, Subject and LLMName label its metrics.
+type llmCall struct {
+	Model    *genai.GenerativeModel
+	LLMName  string
+	Phase    string
+	Subject  string
+	Policy   retryPolicy
+	Limiter  *modelLimiter
+	Metrics  *runMetrics
+	Progress *progressTracker
+	Logger   *slog.Logger
+}
+
+// generateWithRetry calls GenerateContent until it succeeds, hits a permanent
+// error or runs out of attempts. A server retry hint longer than the computed
+// backoff takes precedence. Every attempt is admitted by the model's limiter.
+// Canceling ctx stops further attempts but not the one in flight, which is
+// bounded by the policy's RequestTimeout instead.
+func generateWithRetry(ctx context.Context, call llmCall, prompt string) (*genai.GenerateContentResponse, callStats, classifiedError, error) {
+
+	var lastClass classifiedError
+	var lastErr error
+	var stats callStats
+
+	model, policy, limiter, logger := call.Model, call.Policy, call.Limiter, call.Logger
+
+	estimatedTokens := estimateTokens(prompt) + expectedOutputTokens
+	if model.SystemInstruction != nil {
+		for _, part := range model.SystemInstruction.Parts {
+			if txt, ok := part.(genai.Text); ok {
+				estimatedTokens += estimateTokens(string(txt))
+			}
+		}
+	}
+
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+
+		if err := ctx.Err(); err != nil {
+			return nil, stats, classifiedError{Class: errorClassCanceled, Reason: "canceled"}, err
+		}
+
+		if err := limiter.wait(ctx, estimatedTokens); err != nil {
+			return nil, stats, classifiedError{Class: errorClassCanceled, Reason: "canceled"}, err
+		}
+
+		if err := limiter.acquire(ctx); err != nil {
+			return nil, stats, classifiedError{Class: errorClassCanceled, Reason: "canceled"}, err
+		}
+
+		start := time.Now()
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), policy.RequestTimeout)
+		resp, err := model.GenerateContent(callCtx, genai.Text(prompt))
+		cancel()
+		limiter.release()
+
+		latency := time.Since(start)
+		stats.Attempts = attempt
+		stats.Latency += latency
+		if resp != nil && resp.UsageMetadata != nil {
+			stats.PromptTokens += int64(resp.UsageMetadata.PromptTokenCount)
+			stats.CandidateTokens += int64(resp.UsageMetadata.CandidatesTokenCount)
+			limiter.settle(estimatedTokens, int(resp.UsageMetadata.TotalTokenCount))
+			call.Metrics.observeAttempt(call, latency, resp.UsageMetadata.PromptTokenCount, resp.UsageMetadata.CandidatesTokenCount)
+		} else {
+			call.Metrics.observeAttempt(call, latency, 0, 0)
+		}
+		if err == nil {
+			logger.Debug("llm call succeeded", "attempt", attempt, "latency", latency,
+				"prompt_tokens", stats.PromptTokens, "candidate_tokens", stats.CandidateTokens)
+			return resp, stats, classifiedError{}, nil
+		}
+
+		lastErr = err
+		lastClass = classifyError(err)
+
+		if lastClass.Class != errorClassRetryable || attempt == policy.MaxAttempts || ctx.Err() != nil {
+			call.Metrics.observeFailure(call, lastClass.Class, false)
+			call.Progress.observeFailure(false)
+			return resp, stats, lastClass, err
+		}
+
+		call.Metrics.observeFailure(call, lastClass.Class, true)
+		call.Progress.observeFailure(true)
+
+		delay := policy.backoff(attempt)
+		if lastClass.RetryAfter > delay {
+			delay = lastClass.RetryAfter
+		}
+
+		logger.Warn("llm call failed, retrying", "attempt", attempt, "latency", latency, "reason", lastClass.Reason,
+			"retry_after", lastClass.RetryAfter, "delay", delay, "err", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, stats, classifiedError{Class: errorClassCanceled, Reason: "canceled"}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil, stats, lastClass, lastErr
+}
+
+type failedCell struct {
+	Phase       string
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	Attempts    int
+	Class       errorClass
+	Reason      string
+	Err         error
+}
+
+type failureLog struct {
+	mu    sync.Mutex
+	cells []failedCell
+}
+
+func (f *failureLog) add(cell failedCell) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cells = append(f.cells, cell)
+}
+
+func (f *failureLog) all() []failedCell {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]failedCell(nil), f.cells...)
+}
+
+func (f *failureLog) report(fileName string) {
+
+	cells := f.all()
+	if len(cells) == 0 {
+		return
+	}
+
+	fmt.Println("Failed Cells")
+	fmt.Println("----------------------------------------------------")
+	for _, c := range cells {
+		fmt.Println(c.Phase, c.Subject, c.Topic, c.Proficiency, c.Complexity, "attempts", c.Attempts, c.Class, c.Reason, ":", c.Err)
+	}
+	fmt.Println("----------------------------------------------------")
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		slog.Error("failed cells report not written", "file", fileName, "err", err)
+		return
+	}
+	defer file.Close()
+	sep := ";"
+
+	file.WriteString("Phase" + sep + "Subject" + sep + "Topic" + sep + "Proficiency" + sep + "Complexity" + sep +
+		"Attempts" + sep + "Class" + sep + "Reason" + sep + "Error" + "\n")
+
+	for _, c := range cells {
+		file.WriteString(c.Phase + sep + c.Subject + sep + c.Topic + sep + c.Proficiency + sep + c.Complexity + sep +
+			strconv.Itoa(c.Attempts) + sep + c.Class.String() + sep + c.Reason + sep +
+			strings.ReplaceAll(fmt.Sprint(c.Err), "\n", " ") + "\n")
+	}
+
+	file.Sync()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+func TestClassifyError(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		err        error
+		class      errorClass
+		reason     string
+		retryAfter time.Duration
+	}{
+		{name: "canceled", err: fmt.Errorf("call: %w", context.Canceled), class: errorClassCanceled, reason: "canceled"},
+		{name: "deadline", err: context.DeadlineExceeded, class: errorClassRetryable, reason: "timeout"},
+		{name: "connection reset", err: io.ErrUnexpectedEOF, class: errorClassRetryable, reason: "connection reset"},
+		{name: "blocked", err: &genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonSafety}},
+			class: errorClassPermanent, reason: "safety block: " + genai.FinishReasonSafety.String()},
+		{name: "http 429 with retry-after", err: &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": {"7"}}},
+			class: errorClassRetryable, reason: "rate limited", retryAfter: 7 * time.Second},
+		{name: "http 503", err: &googleapi.Error{Code: 503}, class: errorClassRetryable, reason: "server error 503"},
+		{name: "http 408", err: &googleapi.Error{Code: 408}, class: errorClassRetryable, reason: "timeout"},
+		{name: "http 403", err: &googleapi.Error{Code: 403}, class: errorClassPermanent, reason: "invalid api key"},
+		{name: "http 400", err: &googleapi.Error{Code: 400}, class: errorClassPermanent, reason: "client error 400"},
+		{name: "wrapped http", err: fmt.Errorf("generate: %w", &googleapi.Error{Code: 500}), class: errorClassRetryable,
+			reason: "server error 500"},
+		{name: "invalid key message", err: errors.New("API key not valid. Please pass a valid API key."),
+			class: errorClassPermanent, reason: "invalid api key"},
+		{name: "exhausted message", err: errors.New("rpc error: code = RESOURCE_EXHAUSTED"), class: errorClassRetryable,
+			reason: "rate limited"},
+		{name: "unknown", err: errors.New("something odd"), class: errorClassRetryable, reason: "unknown error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := classifyError(test.err)
+			if got.Class != test.class || got.Reason != test.reason || got.RetryAfter != test.retryAfter {
+				t.Errorf("classifyError() = %v %q %v, want %v %q %v", got.Class, got.Reason, got.RetryAfter,
+					test.class, test.reason, test.retryAfter)
+			}
+		})
+	}
+}
+
+func TestClassifyGRPCCode(t *testing.T) {
+
+	tests := []struct {
+		code   codes.Code
+		class  errorClass
+		reason string
+	}{
+		{code: codes.ResourceExhausted, class: errorClassRetryable, reason: "rate limited"},
+		{code: codes.DeadlineExceeded, class: errorClassRetryable, reason: "timeout"},
+		{code: codes.Unavailable, class: errorClassRetryable, reason: "server error " + codes.Unavailable.String()},
+		{code: codes.PermissionDenied, class: errorClassPermanent, reason: "invalid api key"},
+		{code: codes.Canceled, class: errorClassCanceled, reason: "canceled"},
+		{code: codes.InvalidArgument, class: errorClassPermanent, reason: "client error " + codes.InvalidArgument.String()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.code.String(), func(t *testing.T) {
+			got := classifyGRPCCode(test.code, 3*time.Second)
+			if got.Class != test.class || got.Reason != test.reason {
+				t.Errorf("classifyGRPCCode() = %v %q, want %v %q", got.Class, got.Reason, test.class, test.reason)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	if got := parseRetryAfter(http.Header{}); got != 0 {
+		t.Errorf("parseRetryAfter(none) = %v, want 0", got)
+	}
+	if got := parseRetryAfter(http.Header{"Retry-After": {"12"}}); got != 12*time.Second {
+		t.Errorf("parseRetryAfter(12) = %v, want 12s", got)
+	}
+	if got := parseRetryAfter(http.Header{"Retry-After": {"soon"}}); got != 0 {
+		t.Errorf("parseRetryAfter(soon) = %v, want 0", got)
+	}
+	when := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(http.Header{"Retry-After": {when}}); got <= 20*time.Second || got > 30*time.Second {
+		t.Errorf("parseRetryAfter(%s) = %v, want about 30s", when, got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	policy := retryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+
+	tests := []struct {
+		attempt int
+		full    time.Duration
+	}{
+		{attempt: 1, full: time.Second},
+		{attempt: 2, full: 2 * time.Second},
+		{attempt: 4, full: 8 * time.Second},
+		{attempt: 5, full: 10 * time.Second},
+		{attempt: 20, full: 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.attempt), func(t *testing.T) {
+			low := time.Duration(float64(test.full) * (1 - policy.Jitter))
+			for range 200 {
+				if got := policy.backoff(test.attempt); got < low || got > test.full {
+					t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempt, got, low, test.full)
+				}
+			}
+		})
+	}
+
+	policy.Jitter = 0
+	if got := policy.backoff(3); got != 4*time.Second {
+		t.Errorf("backoff(3) without jitter = %v, want 4s", got)
+	}
+}
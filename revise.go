@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const phaseRevision = "revision"
+
+// revisedAssessment is a question as returned by the revision prompt, naming
+// the question it replaces and the critique behind the change.
+type revisedAssessment struct {
+	assessmentDataforMap
+	OriginalQuestion string
+	Critique         string
+}
+
+// revisionChange records what the revision pass did to one question, with
+// the question as generated and as revised.
+type revisionChange struct {
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	Changed     []string
+	Critique    string
+	Original    assessmentDataforMap
+	Revised     assessmentDataforMap
+}
+
+func getPromptForRevision(proficiency string, complexity string, topic string, subTopic string, batch string) string {
+
+	stepsPrompt := `The following assessment bank was generated for evaluating the Proficiency of a %s with questions of %s Complexity
+	on the Topic of %s within the context of %s. Assessments are delimited by $$$
+
+	$$$
+	%s
+	$$$
+
+	Critique each Question against the following rules and revise the ones that break them
+	Rule 1) No Question repeats or rephrases another Question in the bank.
+	Rule 2) The Question is unambiguous and exactly one of AllOptions is right.
+	Rule 3) The Answer and every other option have a maximum length of no more than 5 words.
+	Rule 4) The 3 other options are similar to the Answer and plausible.
+	Rule 5) The Question fits %s Complexity and the Proficiency of a %s.
+	Rule 6) The Reasoning explains in detail why the Answer is right.
+
+	Return every Question of the bank, revised or not, using the JSON schema of the bank with two more fields
+	'OriginalQuestion': string, the Question as it was in the bank
+	'Critique': string, which rules the original broke and what was changed, or None if nothing was changed
+	Return: Array<Assessment>`
+
+	return fmt.Sprintf(stepsPrompt, proficiency, complexity, subTopic, topic, batch, complexity, proficiency)
+}
+
+// responseText joins the text parts of a response.
+func responseText(resp *genai.GenerateContentResponse) string {
+
+	var texts []string
+	for _, cand := range resp.Candidates {
+		if cand == nil || cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if txt, ok := part.(genai.Text); ok {
+				texts = append(texts, string(txt))
+			}
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+func getAllRevisedResponse(logger *slog.Logger, resp *genai.GenerateContentResponse) []revisedAssessment {
+
+	var revised []revisedAssessment
+	for _, cand := range resp.Candidates {
+		if cand == nil || cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if txt, ok := part.(genai.Text); ok {
+				var dataString []revisedAssessment
+				if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
+					logger.Warn("unparseable revision response", "err", err)
+					continue
+				}
+				revised = append(revised, dataString...)
+			}
+		}
+	}
+
+	return revised
+}
+
+// applyRevision replaces the original questions with their revisions. Only
+// revisions naming a question of the batch and keeping four options are
+// taken; other questions stay as they were. The Revision column lists the
+// changed fields.
+func applyRevision(original map[string]assessmentDataforMap, revised []revisedAssessment) (map[string]assessmentDataforMap, []revisionChange) {
+
+	result := make(map[string]assessmentDataforMap)
+	var changes []revisionChange
+	replaced := make(map[string]bool)
+
+	for _, r := range revised {
+		originalQuestion := r.OriginalQuestion
+		if originalQuestion == "" {
+			originalQuestion = r.Question
+		}
+		before, ok := original[originalQuestion]
+		if !ok || replaced[originalQuestion] || r.Question == "" || (len(r.AllOptions) > 0 && len(r.AllOptions) != 4) {
+			continue
+		}
+		replaced[originalQuestion] = true
+
+		after := before
+		var changed []string
+		if r.Question != before.Question {
+			after.Question = r.Question
+			changed = append(changed, "Question")
+		}
+		if r.Answer != "" && r.Answer != before.Answer {
+			after.Answer = r.Answer
+			changed = append(changed, "Answer")
+		}
+		if len(r.AllOptions) == 4 && !slices.Equal(r.AllOptions, before.AllOptions) {
+			after.AllOptions = r.AllOptions
+			changed = append(changed, "AllOptions")
+		}
+		if r.Reasoning != "" && r.Reasoning != before.Reasoning {
+			after.Reasoning = r.Reasoning
+			changed = append(changed, "Reasoning")
+		}
+		if r.Complexity != "" && r.Complexity != before.Complexity {
+			after.Complexity = r.Complexity
+			changed = append(changed, "Complexity")
+		}
+		after.Revision = strings.Join(changed, ",")
+
+		result[after.Question] = after
+		if len(changed) > 0 {
+			changes = append(changes, revisionChange{Subject: before.Subject, Topic: before.Topic, Proficiency: before.Proficiency,
+				Complexity: before.RequestedComplexity, Changed: changed, Critique: r.Critique, Original: before, Revised: after})
+		}
+	}
+
+	for question, assessment := range original {
+		if !replaced[question] {
+			result[question] = assessment
+		}
+	}
+
+	return result, changes
+}
+
+// reportRevisions prints how many questions the revision pass changed and
+// persists every change as JSON.
+func (s *scheduler) reportRevisions(fileName string) {
+
+	s.mu.Lock()
+	var changes []revisionChange
+	for _, name := range s.topicOrder {
+		changes = append(changes, s.topics[name].Revisions...)
+	}
+	s.mu.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Println("Revisions")
+	fmt.Println("----------------------------------------------------")
+	fmt.Printf("Questions changed by the revision pass %d\n", len(changes))
+	fmt.Println("----------------------------------------------------")
+
+	content, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		slog.Error("revisions not written", "file", fileName, "err", err)
+		return
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		slog.Error("revisions not written", "file", fileName, "err", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+var safetyCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	"block_none":             genai.HarmBlockNone,
+	"block_only_high":        genai.HarmBlockOnlyHigh,
+	"block_medium_and_above": genai.HarmBlockMediumAndAbove,
+	"block_low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+var safetyProbabilities = map[string]genai.HarmProbability{
+	"negligible": genai.HarmProbabilityNegligible,
+	"low":        genai.HarmProbabilityLow,
+	"medium":     genai.HarmProbabilityMedium,
+	"high":       genai.HarmProbabilityHigh,
+}
+
+// parseSafetySettings reads settings in the form
+// "harassment=block_none,dangerous_content=block_only_high".
+// Categories left out keep the API default.
+func parseSafetySettings(value string, settings map[genai.HarmCategory]genai.HarmBlockThreshold) error {
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		categoryName, thresholdName, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("safety setting %q: expected category=threshold", entry)
+		}
+		category, ok := safetyCategories[strings.ToLower(strings.TrimSpace(categoryName))]
+		if !ok {
+			return fmt.Errorf("safety setting %q: unknown category %q", entry, categoryName)
+		}
+		threshold, ok := safetyThresholds[strings.ToLower(strings.TrimSpace(thresholdName))]
+		if !ok {
+			return fmt.Errorf("safety setting %q: unknown threshold %q", entry, thresholdName)
+		}
+
+		settings[category] = threshold
+	}
+
+	return nil
+}
+
+func safetySettingsList(settings map[genai.HarmCategory]genai.HarmBlockThreshold) []*genai.SafetySetting {
+
+	var list []*genai.SafetySetting
+	for category, threshold := range settings {
+		list = append(list, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	slices.SortFunc(list, func(a, b *genai.SafetySetting) int { return int(a.Category) - int(b.Category) })
+
+	return list
+}
+
+type safetyRating struct {
+	Category    string
+	Probability string
+	Blocked     bool
+}
+
+// responseSafety is what one candidate reported about why it stopped and how
+// it was rated.
+type responseSafety struct {
+	FinishReason string
+	Ratings      []safetyRating
+	elevated     []string
+}
+
+// ratedSafety keeps the ratings of a candidate or of a blocked prompt and
+// notes those at or above the flag level.
+func ratedSafety(finishReason string, ratings []*genai.SafetyRating, flagAt genai.HarmProbability) responseSafety {
+
+	safety := responseSafety{FinishReason: finishReason}
+
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		safety.Ratings = append(safety.Ratings, safetyRating{Category: rating.Category.String(),
+			Probability: rating.Probability.String(), Blocked: rating.Blocked})
+		if rating.Blocked || rating.Probability >= flagAt {
+			safety.elevated = append(safety.elevated, rating.Category.String()+"="+rating.Probability.String())
+		}
+	}
+
+	return safety
+}
+
+// responseSafetyOf collects the safety of every candidate of a response. A
+// blocked call has no response; its safety is taken from the error instead.
+func responseSafetyOf(resp *genai.GenerateContentResponse, err error, flagAt genai.HarmProbability) []responseSafety {
+
+	var safety []responseSafety
+
+	var blockedErr *genai.BlockedError
+	if resp == nil && errors.As(err, &blockedErr) {
+		if blockedErr.PromptFeedback != nil {
+			safety = append(safety, ratedSafety("prompt "+blockedErr.PromptFeedback.BlockReason.String(),
+				blockedErr.PromptFeedback.SafetyRatings, flagAt))
+		}
+		if blockedErr.Candidate != nil {
+			safety = append(safety, ratedSafety(blockedErr.Candidate.FinishReason.String(), blockedErr.Candidate.SafetyRatings, flagAt))
+		}
+		return safety
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	for _, cand := range resp.Candidates {
+		if cand != nil {
+			safety = append(safety, ratedSafety(cand.FinishReason.String(), cand.SafetyRatings, flagAt))
+		}
+	}
+
+	return safety
+}
+
+// unusualFinish lists the finish reasons other than a normal stop, such as
+// truncation at the token limit.
+func unusualFinish(safety []responseSafety) []string {
+	var reasons []string
+	for _, s := range safety {
+		if s.FinishReason != genai.FinishReasonStop.String() {
+			reasons = append(reasons, s.FinishReason)
+		}
+	}
+	return reasons
+}
+
+// flagForReview copies the finish reason onto every question of a batch and
+// marks them for review when any candidate of the response was rated at or
+// above the flag level. It returns the review note, empty when none is due.
+func flagForReview(assessments map[string]assessmentDataforMap, safety []responseSafety) string {
+
+	var finishReasons, elevated []string
+	for _, s := range safety {
+		if !slices.Contains(finishReasons, s.FinishReason) {
+			finishReasons = append(finishReasons, s.FinishReason)
+		}
+		elevated = append(elevated, s.elevated...)
+	}
+
+	review := strings.Join(elevated, " ")
+
+	for question, assessment := range assessments {
+		assessment.FinishReason = strings.Join(finishReasons, " ")
+		assessment.SafetyReview = review
+		assessments[question] = assessment
+	}
+
+	return review
+}
+
+// describeBlocked explains a safety block from its prompt feedback or the
+// blocked candidate.
+func describeBlocked(blockedErr *genai.BlockedError) string {
+
+	reason := "safety block"
+
+	if blockedErr.PromptFeedback != nil {
+		reason += ": prompt " + blockedErr.PromptFeedback.BlockReason.String()
+		for _, rating := range blockedErr.PromptFeedback.SafetyRatings {
+			if rating != nil && rating.Blocked {
+				reason += " " + rating.Category.String() + "=" + rating.Probability.String()
+			}
+		}
+	}
+
+	if blockedErr.Candidate != nil {
+		reason += ": " + blockedErr.Candidate.FinishReason.String()
+		for _, rating := range blockedErr.Candidate.SafetyRatings {
+			if rating != nil && rating.Blocked {
+				reason += " " + rating.Category.String() + "=" + rating.Probability.String()
+			}
+		}
+	}
+
+	return reason
+}
+
+// safetyRecord is what the response to one generation cell or validation
+// batch reported, kept whether or not any question came of it.
+type safetyRecord struct {
+	Phase       string
+	Subject     string
+	Topic       string
+	Proficiency string
+	Complexity  string
+	Questions   int
+	Safety      []responseSafety
+}
+
+// safetyLog keeps the safety of every response of the run, including the
+// blocked, truncated and unparseable ones that yield no question.
+type safetyLog struct {
+	mu      sync.Mutex
+	records []safetyRecord
+}
+
+func (l *safetyLog) add(record safetyRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record)
+}
+
+// report prints how the responses finished and writes one row per candidate,
+// or per response without any, with its ratings.
+func (l *safetyLog) report(fileName string) {
+
+	l.mu.Lock()
+	records := append([]safetyRecord(nil), l.records...)
+	l.mu.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+
+	sep := ";"
+	finished := make(map[string]int)
+	var rows []string
+	for _, record := range records {
+		safety := record.Safety
+		if len(safety) == 0 {
+			safety = []responseSafety{{FinishReason: "none"}}
+		}
+		for _, s := range safety {
+			var ratings []string
+			for _, rating := range s.Ratings {
+				note := rating.Category + "=" + rating.Probability
+				if rating.Blocked {
+					note += " blocked"
+				}
+				ratings = append(ratings, note)
+			}
+			finished[s.FinishReason]++
+			rows = append(rows, record.Phase+sep+record.Subject+sep+record.Topic+sep+record.Proficiency+sep+record.Complexity+sep+
+				strconv.Itoa(record.Questions)+sep+s.FinishReason+sep+strings.Join(ratings, ","))
+		}
+	}
+
+	fmt.Println("Response Safety")
+	fmt.Println("----------------------------------------------------")
+	for _, reason := range slices.Sorted(maps.Keys(finished)) {
+		fmt.Printf("%-28s %d\n", reason, finished[reason])
+	}
+	fmt.Println("----------------------------------------------------")
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		slog.Error("safety report not written", "file", fileName, "err", err)
+		return
+	}
+	defer file.Close()
+
+	file.WriteString("Phase" + sep + "Subject" + sep + "Topic" + sep + "Proficiency" + sep + "Complexity" + sep +
+		"Questions" + sep + "FinishReason" + sep + "Ratings" + "\n")
+	for _, row := range rows {
+		file.WriteString(row + "\n")
+	}
+
+	file.Sync()
+}
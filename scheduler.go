@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+const assessmentBankCount int = 3
+
+var profList = []string{"Learner", "Practitioner", "Specialist"}
+
+var complexityList = []string{"Easy", "Medium", "Difficult"}
+
+// topicState collects everything produced for one row of the topics file
+// until all of its generation cells and validation batches are settled.
+type topicState struct {
+	Record         []string
+	ResultsMap     map[string]assessmentDataforMap
+	Batches        []validationBatch
+	Validated      map[string]assessmentValidatedData
+	PendingCells   int
+	PendingBatches int
+	SkippedCells   int
+	Generated      int
+	Duplicates     int
+	NearDuplicates int
+	Unparseable    map[string]int
+	Rejected       map[string]assessmentDataforMap
+	Final          map[string]assessmentDataforMap
+	Revisions      []revisionChange
+	Finalized      bool
+}
+
+// reject keeps the questions failing lint apart from those to validate.
+func (t *topicState) reject(assessments map[string]assessmentDataforMap) {
+	t.Generated += len(assessments)
+	maps.Copy(t.Rejected, assessments)
+}
+
+// merge adds a generated batch to the topic results, counting questions that
+// were already there.
+func (t *topicState) merge(assessments map[string]assessmentDataforMap) {
+	t.Generated += len(assessments)
+	for question, assessment := range assessments {
+		if _, ok := t.ResultsMap[question]; ok {
+			t.Duplicates++
+		}
+		t.ResultsMap[question] = assessment
+	}
+}
+
+func (t *topicState) name() string {
+	return t.Record[0] + "-" + t.Record[1]
+}
+
+// scheduler runs the generation cells of every topic through one generation
+// pool and hands each generated batch to the validation pool as soon as it
+// is parsed, so validation overlaps generation across topics. Pool sizes
+// follow the per-model concurrency limits.
+type scheduler struct {
+	svc *runServices
+
+	// bank holds the earlier questions, avoidTokens bounds how many of the
+	// known stems of a topic go into its generation prompts
+	bank        []*bankQuestion
+	avoidTokens int
+
+	mu                  sync.Mutex
+	topics              map[string]*topicState
+	topicOrder          []string
+	completedTopics     []string
+	interruptedTopics   []string
+	budgetLimitedTopics []string
+	calibration         calibrationSummary
+	citations           citationSummary
+}
+
+func newScheduler(svc *runServices) *scheduler {
+	return &scheduler{
+		svc:    svc,
+		topics: make(map[string]*topicState),
+	}
+}
+
+// restore loads the topics and replays the journal, returning the generation
+// inputs and validation batches that still have to run.
+func (s *scheduler) restore(record [][]string) ([][]string, []validationBatch) {
+
+	var pendingInputs [][]string
+	var pendingBatches []validationBatch
+	restoredCells := 0
+	restoredBatches := 0
+	totalCells := 0
+
+	for recordIteration := range record {
+		topic := &topicState{
+			Record:      record[recordIteration],
+			ResultsMap:  make(map[string]assessmentDataforMap),
+			Validated:   make(map[string]assessmentValidatedData),
+			Unparseable: make(map[string]int),
+			Rejected:    make(map[string]assessmentDataforMap),
+		}
+		s.topics[topic.name()] = topic
+		s.topicOrder = append(s.topicOrder, topic.name())
+
+		row := s.svc.topicRows[topic.name()]
+		for idx := range profList {
+			for cidx := range complexityList {
+				if !row.allows(profList[idx], complexityList[cidx]) {
+					continue
+				}
+				totalCells++
+				var dataInput []string
+				dataInput = append(dataInput, profList[idx])
+				dataInput = append(dataInput, complexityList[cidx])
+				dataInput = append(dataInput, topic.Record[0])
+				dataInput = append(dataInput, topic.Record[1])
+
+				key := cellKey(dataInput[2], dataInput[3], dataInput[0], dataInput[1])
+
+				entry, ok := s.svc.journal.generationDone(key)
+				if !ok {
+					pendingInputs = append(pendingInputs, dataInput)
+					topic.PendingCells++
+					continue
+				}
+
+				restoredCells++
+				s.svc.safetyLog.add(safetyRecord{Phase: phaseGeneration, Subject: entry.Subject, Topic: entry.Topic,
+					Proficiency: entry.Proficiency, Complexity: entry.Complexity, Questions: len(entry.Assessments), Safety: entry.Safety})
+				s.svc.ledger.add(phaseGeneration, entry.Subject, entry.Topic, entry.LLMName, entry.Usage)
+				s.svc.ledger.add(phaseRevision, entry.Subject, entry.Topic, entry.LLMName, entry.RevisionUsage)
+				topic.Revisions = append(topic.Revisions, entry.Revisions...)
+				restored := make(map[string]assessmentDataforMap)
+				for aidx := range entry.Assessments {
+					restored[entry.Assessments[aidx].Question] = entry.Assessments[aidx]
+				}
+				accepted, rejected := lintBatch(restored, s.svc.lint)
+				topic.merge(accepted)
+				topic.reject(rejected)
+				if len(accepted) == 0 || entry.PromptforValidation == "" {
+					continue
+				}
+				batch := validationBatch{Subject: entry.Subject, Topic: entry.Topic, Proficiency: entry.Proficiency,
+					Complexity: entry.Complexity, Prompt: entry.PromptforValidation}
+				topic.Batches = append(topic.Batches, batch)
+
+				if validated, ok := s.svc.journal.validationDone(key); ok {
+					restoredBatches++
+					s.svc.safetyLog.add(safetyRecord{Phase: phaseValidation, Subject: validated.Subject, Topic: validated.Topic,
+						Proficiency: validated.Proficiency, Complexity: validated.Complexity, Questions: len(validated.Validated),
+						Safety: validated.Safety})
+					s.svc.ledger.add(phaseValidation, validated.Subject, validated.Topic, validated.LLMName, validated.Usage)
+					for vidx := range validated.Validated {
+						topic.Validated[validated.Validated[vidx].Question] = validated.Validated[vidx]
+					}
+				} else {
+					pendingBatches = append(pendingBatches, batch)
+					topic.PendingBatches++
+				}
+			}
+		}
+	}
+
+	s.svc.budget.restored(restoredCells)
+	s.svc.progress.restored(totalCells, restoredCells, restoredBatches, len(pendingBatches))
+
+	s.svc.logger.Info("journal replayed", "restored_cells", restoredCells, "pending_cells", len(pendingInputs),
+		"pending_batches", len(pendingBatches))
+
+	return pendingInputs, pendingBatches
+}
+
+func (s *scheduler) run(ctx context.Context, record [][]string) {
+
+	pendingInputs, pendingBatches := s.restore(record)
+
+	generationCount := s.svc.limiters.concurrency(generationLLMName)
+	validationCount := s.svc.limiters.concurrency(validationLLMName)
+
+	tracker := make(chan empty)
+	chanInputs := make(chan []string)
+	geminiResponse := make(chan llmResponse)
+
+	trackerforValdation := make(chan empty)
+	chanInputsforValidation := make(chan []string)
+	geminiResponseforValidation := make(chan llmResponse)
+
+	for i := 0; i < generationCount; i++ {
+		go worker(ctx, tracker, assessmentBankCount, chanInputs, geminiResponse, i, s.svc)
+	}
+	for i := 0; i < validationCount; i++ {
+		go workerforValidation(ctx, trackerforValdation, chanInputsforValidation, geminiResponseforValidation, i, s.svc)
+	}
+
+	// Both the restored batches and the generation collector feed validation
+	var validationFeeders sync.WaitGroup
+	validationFeeders.Add(2)
+
+	go func() {
+		defer validationFeeders.Done()
+		for pidx := range pendingBatches {
+			if !s.dispatchValidation(ctx, chanInputsforValidation, pendingBatches[pidx]) {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer validationFeeders.Done()
+		for r := range geminiResponse {
+			if batch, ok := s.collectGeneration(r); ok {
+				s.dispatchValidation(ctx, chanInputsforValidation, batch)
+			}
+		}
+	}()
+
+	validationDone := make(chan empty)
+	go func() {
+		for r := range geminiResponseforValidation {
+			s.collectValidation(r)
+		}
+		close(validationDone)
+	}()
+
+dispatch:
+	for pidx := range pendingInputs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if ok, reason := s.svc.budget.admit(pendingInputs[pidx]); !ok {
+			s.skipCell(pendingInputs[pidx], reason)
+			continue
+		}
+
+		input := append(slices.Clip(pendingInputs[pidx]), s.avoidList(pendingInputs[pidx][2], pendingInputs[pidx][3], s.avoidTokens))
+
+		select {
+		case chanInputs <- input:
+		case <-ctx.Done():
+			s.svc.budget.release(pendingInputs[pidx][2], false)
+			break dispatch
+		}
+	}
+
+	close(chanInputs)
+	for i := 0; i < generationCount; i++ {
+		<-tracker
+	}
+	close(geminiResponse)
+
+	validationFeeders.Wait()
+	close(chanInputsforValidation)
+	for i := 0; i < validationCount; i++ {
+		<-trackerforValdation
+	}
+	close(geminiResponseforValidation)
+	<-validationDone
+
+	// Topics restored whole from the journal are written here, as is
+	// whatever was cut short by cancellation
+	for _, name := range s.topicOrder {
+		topic := s.topics[name]
+		if !topic.Finalized {
+			s.finalizeTopic(topic, topic.PendingCells == 0 && topic.PendingBatches == 0)
+		}
+	}
+}
+
+func (s *scheduler) dispatchValidation(ctx context.Context, chanInputsforValidation chan []string, batch validationBatch) bool {
+
+	var dataInput []string
+	dataInput = append(dataInput, batch.Prompt)
+	dataInput = append(dataInput, batch.Subject)
+	dataInput = append(dataInput, batch.Topic)
+	dataInput = append(dataInput, batch.Proficiency)
+	dataInput = append(dataInput, batch.Complexity)
+
+	select {
+	case chanInputsforValidation <- dataInput:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// collectGeneration merges one generation response into its topic and
+// returns the validation batch built from the questions that passed lint,
+// if any.
+func (s *scheduler) collectGeneration(r llmResponse) (validationBatch, bool) {
+
+	batch := validationBatch{Subject: r.Input[2], Topic: r.Input[3], Proficiency: r.Input[0], Complexity: r.Input[1]}
+
+	logger := cellLogger(s.svc.logger, phaseGeneration, generationLLMName, batch.Subject, batch.Topic, batch.Proficiency, batch.Complexity)
+
+	var rMap, rejected map[string]assessmentDataforMap
+	var revisions []revisionChange
+	safety := responseSafetyOf(r.Resp, r.Err, s.svc.safetyFlagAt)
+	if reasons := unusualFinish(safety); len(reasons) > 0 {
+		logger.Warn("generation response did not finish normally", "finish_reason", reasons)
+	}
+	if r.Resp != nil {
+		rMap = getAllResponseMap(logger, r.Resp)
+		for question, assessment := range rMap {
+			assessment.RequestedComplexity = batch.Complexity
+			rMap[question] = assessment
+		}
+		if r.Revised != nil && len(rMap) > 0 {
+			rMap, revisions = applyRevision(rMap, getAllRevisedResponse(logger, r.Revised))
+			if len(revisions) > 0 {
+				logger.Info("generation batch revised", "changed", len(revisions), "questions", len(rMap))
+			}
+		}
+		chunks := s.svc.sources[batch.Subject+"-"+batch.Topic].forCell(batch.Proficiency, batch.Complexity, s.svc.sourceChunks)
+		if uncited := resolveSources(rMap, chunks); uncited > 0 {
+			logger.Warn("questions cite no reference passage", "questions", uncited)
+		}
+		if dropped := tagAssessments(rMap, s.svc.objectives[batch.Subject+"-"+batch.Topic]); dropped > 0 {
+			logger.Warn("unknown learning objectives dropped", "objectives", dropped)
+		}
+		if review := flagForReview(rMap, safety); review != "" && len(rMap) > 0 {
+			logger.Warn("questions flagged for safety review", "ratings", review, "questions", len(rMap))
+		}
+		rMap, rejected = lintBatch(rMap, s.svc.lint)
+		if len(rejected) > 0 {
+			logger.Warn("questions rejected by lint", "rejected", len(rejected), "accepted", len(rMap))
+		}
+		// The validation prompt is built from the accepted questions only
+		if len(rMap) > 0 {
+			batch.Prompt = getPromptRefinedforValidation(slices.Collect(maps.Values(rMap)))
+		}
+		s.svc.metrics.countQuestions(phaseGeneration, batch.Subject, outcomeGenerated, len(rMap)+len(rejected))
+		s.svc.metrics.countQuestions(phaseGeneration, batch.Subject, outcomeLintRejected, len(rejected))
+		logger.Log(context.Background(), s.svc.progress.completionLevel(), "generation cell done", "attempt", r.Stats.Attempts, "latency", r.Stats.Latency, "questions", len(rMap))
+	}
+
+	s.svc.safetyLog.add(safetyRecord{Phase: phaseGeneration, Subject: batch.Subject, Topic: batch.Topic, Proficiency: batch.Proficiency,
+		Complexity: batch.Complexity, Questions: len(rMap) + len(rejected), Safety: safety})
+	s.svc.budget.release(batch.Subject, len(rMap)+len(rejected) > 0)
+	s.svc.progress.cellDone(batch.Prompt != "")
+
+	if len(rMap)+len(rejected) > 0 {
+		generated := append(slices.Collect(maps.Values(rMap)), slices.Collect(maps.Values(rejected))...)
+		err := s.svc.journal.record(journalEntry{Kind: journalKindGeneration, Subject: batch.Subject, Topic: batch.Topic,
+			Proficiency: batch.Proficiency, Complexity: batch.Complexity, Assessments: generated,
+			PromptforValidation: batch.Prompt, Safety: safety, Revisions: revisions,
+			LLMName: generationLLMName, Usage: r.Stats, RevisionUsage: r.RevisionStats})
+		if err != nil {
+			logger.Error("journal write failed", "err", err)
+		}
+	}
+
+	s.mu.Lock()
+	topic := s.topics[batch.Subject+"-"+batch.Topic]
+	topic.PendingCells--
+	if r.Resp != nil && len(rMap)+len(rejected) == 0 {
+		topic.Unparseable[phaseGeneration]++
+	}
+	topic.reject(rejected)
+	topic.Revisions = append(topic.Revisions, revisions...)
+	topic.merge(rMap)
+	if batch.Prompt != "" {
+		topic.Batches = append(topic.Batches, batch)
+		topic.PendingBatches++
+	}
+	ready := topic.PendingCells == 0 && topic.PendingBatches == 0
+	s.mu.Unlock()
+
+	if ready {
+		s.finalizeTopic(topic, true)
+	}
+
+	return batch, batch.Prompt != ""
+}
+
+func (s *scheduler) collectValidation(r llmResponse) {
+
+	batch := validationBatch{Subject: r.Input[1], Topic: r.Input[2], Proficiency: r.Input[3], Complexity: r.Input[4]}
+
+	logger := cellLogger(s.svc.logger, phaseValidation, validationLLMName, batch.Subject, batch.Topic, batch.Proficiency, batch.Complexity)
+
+	var rvMap map[string]assessmentValidatedData
+	safety := responseSafetyOf(r.Resp, r.Err, s.svc.safetyFlagAt)
+	if reasons := unusualFinish(safety); len(reasons) > 0 {
+		logger.Warn("validation response did not finish normally", "finish_reason", reasons)
+	}
+	if r.Resp != nil {
+		rvMap = getAllValidatedResponseMap(logger, r.Resp)
+		s.svc.metrics.countQuestions(phaseValidation, batch.Subject, outcomeValidated, len(rvMap))
+		logger.Log(context.Background(), s.svc.progress.completionLevel(), "validation batch done", "attempt", r.Stats.Attempts, "latency", r.Stats.Latency, "questions", len(rvMap))
+	}
+
+	s.svc.safetyLog.add(safetyRecord{Phase: phaseValidation, Subject: batch.Subject, Topic: batch.Topic, Proficiency: batch.Proficiency,
+		Complexity: batch.Complexity, Questions: len(rvMap), Safety: safety})
+
+	if len(rvMap) > 0 {
+		err := s.svc.journal.record(journalEntry{Kind: journalKindValidation, Subject: batch.Subject, Topic: batch.Topic,
+			Proficiency: batch.Proficiency, Complexity: batch.Complexity, Validated: slices.Collect(maps.Values(rvMap)),
+			Safety: safety, LLMName: validationLLMName, Usage: r.Stats})
+		if err != nil {
+			logger.Error("journal write failed", "err", err)
+		}
+	}
+
+	s.mu.Lock()
+	topic := s.topics[batch.Subject+"-"+batch.Topic]
+	if r.Resp != nil && len(rvMap) == 0 {
+		topic.Unparseable[phaseValidation]++
+	}
+	maps.Copy(topic.Validated, rvMap)
+	s.mu.Unlock()
+
+	s.settleBatch(batch)
+}
+
+// skipCell gives up on a generation cell the budget does not allow.
+func (s *scheduler) skipCell(chanInput []string, reason string) {
+
+	s.svc.budget.skip(skippedCell{Phase: phaseGeneration, Subject: chanInput[2], Topic: chanInput[3], Proficiency: chanInput[0],
+		Complexity: chanInput[1], Reason: reason})
+	s.svc.progress.cellDone(false)
+
+	s.mu.Lock()
+	topic := s.topics[chanInput[2]+"-"+chanInput[3]]
+	topic.PendingCells--
+	topic.SkippedCells++
+	ready := topic.PendingCells == 0 && topic.PendingBatches == 0
+	s.mu.Unlock()
+
+	if ready {
+		s.finalizeTopic(topic, true)
+	}
+}
+
+// settleBatch marks a validation batch as done, whatever its outcome.
+func (s *scheduler) settleBatch(batch validationBatch) {
+
+	s.svc.progress.batchDone()
+
+	s.mu.Lock()
+	topic := s.topics[batch.Subject+"-"+batch.Topic]
+	topic.PendingBatches--
+	ready := topic.PendingCells == 0 && topic.PendingBatches == 0
+	s.mu.Unlock()
+
+	if ready {
+		s.finalizeTopic(topic, true)
+	}
+}
+
+// finalizeTopic writes the assessment and validated assessment files of a
+// topic. complete is false for topics cut short by cancellation.
+func (s *scheduler) finalizeTopic(topic *topicState, complete bool) {
+
+	s.mu.Lock()
+	if topic.Finalized {
+		s.mu.Unlock()
+		return
+	}
+	topic.Finalized = true
+	if complete && topic.SkippedCells > 0 {
+		s.budgetLimitedTopics = append(s.budgetLimitedTopics, topic.name())
+	} else if complete {
+		s.completedTopics = append(s.completedTopics, topic.name())
+	} else {
+		s.interruptedTopics = append(s.interruptedTopics, topic.name())
+	}
+	s.mu.Unlock()
+
+	assessmentfileName := topic.name() + "-" + "Assessment.csv"
+	validatedAssessmentfileName := topic.name() + "-" + "ValidatedAssessment.csv"
+
+	logger := s.svc.logger.With("subject", topic.Record[0], "topic", topic.Record[1])
+
+	generated := maps.Clone(topic.ResultsMap)
+	maps.Copy(generated, topic.Rejected)
+	if err := csvWriteStringFile(generated, assessmentfileName); err != nil {
+		logger.Error("assessment file not written", "file", assessmentfileName, "err", err)
+	}
+
+	resultsMap, mismatchedDataString := updateMaps(logger, topic.ResultsMap, topic.Validated)
+
+	s.mu.Lock()
+	topic.Final = resultsMap
+	s.mu.Unlock()
+
+	mismatched, rejected := 0, 0
+	for _, v := range resultsMap {
+		switch validationVerdict(v) {
+		case verdictDoNotKnow, verdictNotListed:
+			rejected++
+		case verdictMismatched:
+			mismatched++
+		}
+	}
+	s.svc.metrics.countQuestions(phaseValidation, topic.Record[0], outcomeMismatched, mismatched)
+	s.svc.metrics.countQuestions(phaseValidation, topic.Record[0], outcomeRejected, rejected)
+
+	logger.Info("topic finished", "complete", complete, "skipped_cells", topic.SkippedCells, "validated", len(topic.Validated),
+		"questions", len(resultsMap), "mismatched", len(mismatchedDataString), "duplicates", topic.Duplicates,
+		"generated", topic.Generated)
+
+	if err := csvWriteStringFile(resultsMap, validatedAssessmentfileName); err != nil {
+		logger.Error("validated assessment file not written", "file", validatedAssessmentfileName, "err", err)
+	}
+}
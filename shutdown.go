@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted is the conventional exit status for a run stopped by SIGINT.
+const exitInterrupted = 130
+
+const partialMarkerFileName = "PARTIAL"
+
+// withShutdownSignals returns a context that is canceled on the first SIGINT
+// or SIGTERM. Canceling it only stops new work from being dispatched; requests
+// already in flight run on their own timeout. A second signal terminates the
+// process immediately.
+func withShutdownSignals(ctx context.Context) context.Context {
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stop()
+		slog.Warn("interrupt received, finishing in-flight requests; press Ctrl-C again to abort")
+	}()
+
+	return ctx
+}
+
+// writePartialMarker records in the run directory that the run stopped early
+// and which topics were fully processed, so the output files are not mistaken
+// for a complete bank.
+func writePartialMarker(runID string, completedTopics []string, interruptedTopics []string) error {
+
+	var sb strings.Builder
+
+	sb.WriteString("Run " + runID + " was interrupted at " + time.Now().Format(time.RFC3339) + "\n")
+	sb.WriteString(fmt.Sprintf("Completed topics   : %d\n", len(completedTopics)))
+	for _, topic := range completedTopics {
+		sb.WriteString("  " + topic + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("Interrupted topics : %d\n", len(interruptedTopics)))
+	for _, topic := range interruptedTopics {
+		sb.WriteString("  " + topic + "\n")
+	}
+	sb.WriteString("Resume with -run-id " + runID + "\n")
+
+	return os.WriteFile(filepath.Join(runDirectory(runID), partialMarkerFileName), []byte(sb.String()), 0o644)
+}
+
+func clearPartialMarker(runID string) {
+	os.Remove(filepath.Join(runDirectory(runID), partialMarkerFileName))
+}
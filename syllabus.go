@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"gopkg.in/yaml.v3"
+)
+
+const phaseSyllabus = "syllabus"
+
+var systemPromptForSyllabus = `You are an AI Guru and a curriculum designer. You lay out the Topics a Subject should be assessed on
+for Talents of the following Proficiencies, in the increasing order of expertise
+1) Learner      : aware of the basic concepts with little or minimal practical experience.
+2) Practitioner : an advanced understanding of the concepts and meaningful practical experience over a few years.
+3) Specialist   : a GURU on the concepts with meaningful practical experience over many years.`
+
+// syllabusTopic is a topic proposed by the model with its sub-topics.
+type syllabusTopic struct {
+	Topic       string
+	Description string
+	SubTopics   []struct {
+		SubTopic      string
+		Description   string
+		Proficiencies []string
+	}
+}
+
+func newSyllabusModel(client *genai.Client, llmName string) *genai.GenerativeModel {
+
+	model := client.GenerativeModel(llmName)
+	model.ResponseMIMEType = "application/json"
+	// some variety so that a regenerated syllabus differs
+	const ChatTemperature float32 = 0.7
+	temperature := ChatTemperature
+	model.Temperature = &temperature
+
+	model.SystemInstruction = &genai.Content{
+		Parts: []genai.Part{genai.Text(systemPromptForSyllabus)},
+	}
+
+	return model
+}
+
+// parseProficiencyRange reads either a range such as "Learner-Practitioner"
+// or a list such as "Learner|Specialist".
+func parseProficiencyRange(value string) ([]string, error) {
+
+	if from, to, ok := strings.Cut(value, "-"); ok {
+		bounds, err := canonicalNames([]string{from, to}, profList, "proficiency")
+		if err != nil {
+			return nil, err
+		}
+		if len(bounds) == 0 {
+			return nil, fmt.Errorf("proficiency range %q names no proficiency", value)
+		}
+		first, last := slices.Index(profList, bounds[0]), slices.Index(profList, bounds[len(bounds)-1])
+		if first > last {
+			return nil, fmt.Errorf("proficiency range %q runs backwards", value)
+		}
+		return slices.Clone(profList[first : last+1]), nil
+	}
+
+	proficiencies, err := canonicalNames(strings.FieldsFunc(value, func(r rune) bool { return r == '|' || r == ',' }), profList, "proficiency")
+	if err == nil && len(proficiencies) == 0 {
+		err = fmt.Errorf("proficiency range %q names no proficiency", value)
+	}
+
+	return proficiencies, err
+}
+
+func getPromptForSyllabus(subject string, proficiencies []string, topicCount int) string {
+
+	return fmt.Sprintf(`Propose a syllabus of about %d Topics on the Subject of %s for assessing Talents of the following Proficiencies: %s.
+
+	Step 1) List the Topics that together cover the Subject, without overlaps between them.
+	Step 2) For each Topic, Describe in one sentence what it covers as Description.
+	Step 3) For each Topic, Split it into 2 to 5 SubTopics narrow enough to ask a few multiple choice questions on each.
+	Step 4) For each SubTopic, Describe in one sentence what questions on it should test as Description.
+	Step 5) For each SubTopic, List the Proficiencies among %s it is worth assessing at as Proficiencies.
+
+	Return the results using this JSON schema:
+		Topic = {
+		'Topic': string
+		'Description': string
+		'SubTopics': [{'SubTopic': string, 'Description': string, 'Proficiencies': []}]
+		}
+		Return: Array<Topic>`, topicCount, subject, strings.Join(proficiencies, ", "), strings.Join(proficiencies, ", "))
+}
+
+// syllabusRows turns the proposed tree into topic rows: one per sub-topic,
+// restricted to the requested proficiencies, with the description as notes.
+// Repeated sub-topics and those proposed only outside the requested
+// proficiencies are dropped.
+func syllabusRows(subject string, proficiencies []string, topics []syllabusTopic) []topicRow {
+
+	var rows []topicRow
+	add := func(row topicRow) {
+		if !slices.ContainsFunc(rows, func(r topicRow) bool { return strings.EqualFold(r.qualifiedTopic(), row.qualifiedTopic()) }) {
+			rows = append(rows, row)
+		}
+	}
+	for _, topic := range topics {
+		topic.Topic = strings.TrimSpace(strings.ReplaceAll(topic.Topic, "/", " "))
+		if topic.Topic == "" {
+			continue
+		}
+		if len(topic.SubTopics) == 0 {
+			add(topicRow{Subject: subject, Topic: topic.Topic, Proficiencies: proficiencies, Notes: topic.Description})
+			continue
+		}
+		for _, sub := range topic.SubTopics {
+			var allowed []string
+			for _, proficiency := range proficiencies {
+				if slices.ContainsFunc(sub.Proficiencies, func(p string) bool { return strings.EqualFold(strings.TrimSpace(p), proficiency) }) {
+					allowed = append(allowed, proficiency)
+				}
+			}
+			if len(sub.Proficiencies) == 0 {
+				allowed = proficiencies
+			}
+			if len(allowed) == 0 {
+				continue
+			}
+			add(topicRow{Subject: subject, Topic: topic.Topic, SubTopic: strings.ReplaceAll(sub.SubTopic, "/", " "),
+				Proficiencies: allowed, Notes: sub.Description})
+		}
+	}
+
+	return rows
+}
+
+// writeTopics writes rows in the format loadTopics reads, chosen by extension.
+func writeTopics(fileName string, rows []topicRow) error {
+
+	var content []byte
+	var err error
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		content, err = json.MarshalIndent(rows, "", "  ")
+	case ".yaml", ".yml":
+		content, err = yaml.Marshal(rows)
+	default:
+		var builder strings.Builder
+		w := csv.NewWriter(&builder)
+		w.Write(topicColumns)
+		for _, row := range rows {
+			count := ""
+			if row.Count > 0 {
+				count = strconv.Itoa(row.Count)
+			}
+			w.Write([]string{row.Subject, row.Topic, row.SubTopic, count, strings.Join(row.Proficiencies, "|"),
+				strings.Join(row.Complexities, "|"), row.Notes, row.Language})
+		}
+		w.Flush()
+		content, err = []byte(builder.String()), w.Error()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, content, 0o644)
+}
+
+func printSyllabus(subject string, rows []topicRow) {
+
+	fmt.Println("Syllabus " + subject)
+	fmt.Println("----------------------------------------------------")
+	for idx, row := range rows {
+		fmt.Printf("%3d %s [%s]\n    %s\n", idx+1, row.qualifiedTopic(), strings.Join(row.Proficiencies, ", "), row.Notes)
+	}
+	fmt.Println("----------------------------------------------------")
+}
+
+// proposeSyllabus asks the model of call for the topic tree of the subject.
+func proposeSyllabus(ctx context.Context, call llmCall, subject string, proficiencies []string, topicCount int) ([]topicRow, error) {
+
+	resp, _, class, err := generateWithRetry(ctx, call, getPromptForSyllabus(subject, proficiencies, topicCount))
+	if err != nil {
+		return nil, fmt.Errorf("syllabus request failed (%s): %w", class.Class.String(), err)
+	}
+
+	var topics []syllabusTopic
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if txt, ok := part.(genai.Text); ok {
+				var dataString []syllabusTopic
+				if err := json.Unmarshal([]byte(txt), &dataString); err != nil {
+					call.Logger.Warn("unparseable syllabus response", "err", err)
+					continue
+				}
+				topics = append(topics, dataString...)
+			}
+		}
+	}
+
+	rows := syllabusRows(subject, proficiencies, topics)
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no topics proposed for %s", subject)
+	}
+
+	return rows, nil
+}
+
+// expandSyllabus proposes the topics of a subject and writes them to
+// fileName for main to generate from. An existing fileName is only replaced
+// when force is set. On a terminal the proposal is kept in a draft next to
+// fileName, where it can be edited in $EDITOR, regenerated or dropped; only
+// an accepted draft replaces fileName.
+func expandSyllabus(ctx context.Context, logger *slog.Logger, call llmCall, subject string, proficiencies []string,
+	topicCount int, fileName string, force bool) error {
+
+	if _, err := os.Stat(fileName); err == nil && !force {
+		return fmt.Errorf("%s already exists, use -force to replace it", fileName)
+	}
+
+	call.Subject = subject
+	call.Logger = logger.With("phase", phaseSyllabus, "model", call.LLMName, "subject", subject)
+
+	rows, err := proposeSyllabus(ctx, call, subject, proficiencies, topicCount)
+	if err != nil {
+		return err
+	}
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		if err := writeTopics(fileName, rows); err != nil {
+			return err
+		}
+		printSyllabus(subject, rows)
+		logger.Warn("syllabus written unreviewed, check it before generating", "file", fileName, "topics", len(rows))
+		return nil
+	}
+
+	// the draft keeps the extension, which decides its format
+	ext := filepath.Ext(fileName)
+	draft, err := os.CreateTemp(filepath.Dir(fileName), "."+strings.TrimSuffix(filepath.Base(fileName), ext)+"-draft-*"+ext)
+	if err != nil {
+		return err
+	}
+	draftName := draft.Name()
+	draft.Close()
+	defer os.Remove(draftName)
+
+	if err := writeTopics(draftName, rows); err != nil {
+		return err
+	}
+
+	input := bufio.NewScanner(os.Stdin)
+	invalid := false
+	for {
+		printSyllabus(subject, rows)
+		fmt.Print("[a]ccept, [e]dit, [r]egenerate or [q]uit? ")
+		if !input.Scan() {
+			return input.Err()
+		}
+
+		switch strings.ToLower(strings.TrimSpace(input.Text())) {
+		case "a", "accept":
+			if invalid {
+				fmt.Println("Fix the syllabus with [e]dit before accepting it")
+				continue
+			}
+			if err := os.Rename(draftName, fileName); err != nil {
+				return err
+			}
+			logger.Info("syllabus written", "file", fileName, "topics", len(rows), "generate_with", "-topics "+fileName)
+			return nil
+		case "e", "edit":
+			editor := os.Getenv("EDITOR")
+			if editor == "" {
+				editor = "vi"
+			}
+			cmd := exec.Command(editor, draftName)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				logger.Error("editor failed", "editor", editor, "err", err)
+				continue
+			}
+			edited, err := loadTopics(draftName)
+			if err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					logger.Error("invalid syllabus", "err", line)
+				}
+				invalid = true
+				continue
+			}
+			rows, invalid = edited, false
+		case "r", "regenerate":
+			proposed, err := proposeSyllabus(ctx, call, subject, proficiencies, topicCount)
+			if err != nil {
+				logger.Error("syllabus not regenerated", "err", err)
+				continue
+			}
+			rows, invalid = proposed, false
+			if err := writeTopics(draftName, rows); err != nil {
+				return err
+			}
+		case "q", "quit":
+			logger.Info("syllabus dropped", "file", fileName)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseProficiencyRange(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "Learner-Specialist", want: []string{"Learner", "Practitioner", "Specialist"}},
+		{value: "practitioner - specialist", want: []string{"Practitioner", "Specialist"}},
+		{value: "Learner-", want: []string{"Learner"}},
+		{value: "Learner|Specialist", want: []string{"Learner", "Specialist"}},
+		{value: "Specialist-Learner", wantErr: true},
+		{value: "Learner-Expert", wantErr: true},
+		{value: "-", wantErr: true},
+		{value: " - ", wantErr: true},
+		{value: "|", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseProficiencyRange(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseProficiencyRange(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			}
+			if !test.wantErr && !slices.Equal(got, test.want) {
+				t.Errorf("parseProficiencyRange(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
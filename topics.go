@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// topicColumns are the columns of a headered topics CSV. Only Subject and
+// Topic are required; lists are separated by |.
+var topicColumns = []string{"Subject", "Topic", "SubTopic", "Count", "Proficiencies", "Complexities", "Notes", "Language"}
+
+// topicRow is one topic to generate assessments for. SubTopic may be a path
+// such as "Attention/Multi-head", which is appended to the Topic. Count
+// overrides the questions asked per cell, Proficiencies and Complexities
+// restrict the cells, and Notes and Language are passed to the generator.
+type topicRow struct {
+	Subject       string
+	Topic         string
+	SubTopic      string   `json:",omitempty" yaml:",omitempty"`
+	Count         int      `json:",omitempty" yaml:",omitempty"`
+	Proficiencies []string `json:",omitempty" yaml:",omitempty"`
+	Complexities  []string `json:",omitempty" yaml:",omitempty"`
+	Notes         string   `json:",omitempty" yaml:",omitempty"`
+	Language      string   `json:",omitempty" yaml:",omitempty"`
+}
+
+// qualifiedTopic is the topic name used for prompts, files and the journal.
+func (t topicRow) qualifiedTopic() string {
+
+	parts := []string{t.Topic}
+	for _, part := range strings.Split(t.SubTopic, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, " - ")
+}
+
+// allows tells whether the row asks for the cell.
+func (t topicRow) allows(proficiency string, complexity string) bool {
+	return (len(t.Proficiencies) == 0 || slices.Contains(t.Proficiencies, proficiency)) &&
+		(len(t.Complexities) == 0 || slices.Contains(t.Complexities, complexity))
+}
+
+// bankCount is the number of questions asked per cell of the row.
+func (t topicRow) bankCount(defaultCount int) int {
+	if t.Count > 0 {
+		return t.Count
+	}
+	return defaultCount
+}
+
+// canonicalNames maps every name to its spelling in allowed.
+func canonicalNames(names []string, allowed []string, kind string) ([]string, error) {
+
+	var canonical []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		idx := slices.IndexFunc(allowed, func(a string) bool { return strings.EqualFold(a, name) })
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown %s %q, expected one of %s", kind, name, strings.Join(allowed, ", "))
+		}
+		if !slices.Contains(canonical, allowed[idx]) {
+			canonical = append(canonical, allowed[idx])
+		}
+	}
+
+	return canonical, nil
+}
+
+// validate checks one row and brings its proficiencies and complexities to
+// their canonical names.
+func (t *topicRow) validate() error {
+
+	t.Subject = strings.TrimSpace(t.Subject)
+	t.Topic = strings.TrimSpace(t.Topic)
+	if t.Subject == "" || t.Topic == "" {
+		return errors.New("Subject and Topic are required")
+	}
+	if t.Count < 0 {
+		return fmt.Errorf("Count %d must be positive", t.Count)
+	}
+
+	var err error
+	if t.Proficiencies, err = canonicalNames(t.Proficiencies, profList, "proficiency"); err != nil {
+		return err
+	}
+	if t.Complexities, err = canonicalNames(t.Complexities, complexityList, "complexity"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// csvTopicRows reads a topics CSV. Without a header row every row is
+// Subject,Topic as before; with one, the columns are taken by name. Rows
+// that cannot be read come back with their error in rowErrs.
+func csvTopicRows(fileName string) (rows []topicRow, labels []string, rowErrs []error, err error) {
+
+	csvfile, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer csvfile.Close()
+
+	r := csv.NewReader(csvfile)
+	r.FieldsPerRecord = -1
+	record, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	add := func(row topicRow, label string, err error) {
+		rows = append(rows, row)
+		labels = append(labels, label)
+		rowErrs = append(rowErrs, err)
+	}
+
+	if len(record) == 0 || !strings.EqualFold(strings.TrimSpace(record[0][0]), "Subject") {
+		for idx, fields := range record {
+			if len(fields) < 2 {
+				add(topicRow{}, fmt.Sprintf("%s row %d", fileName, idx+1), errors.New("expected Subject,Topic"))
+				continue
+			}
+			add(topicRow{Subject: fields[0], Topic: fields[1]}, fmt.Sprintf("%s row %d", fileName, idx+1), nil)
+		}
+		return rows, labels, rowErrs, nil
+	}
+
+	header := make([]string, len(record[0]))
+	for idx, name := range record[0] {
+		column := slices.IndexFunc(topicColumns, func(c string) bool { return strings.EqualFold(c, strings.TrimSpace(name)) })
+		if column < 0 {
+			return nil, nil, nil, fmt.Errorf("%s header: unknown column %q, expected %s", fileName, name, strings.Join(topicColumns, ", "))
+		}
+		header[idx] = topicColumns[column]
+	}
+
+	for idx, fields := range record[1:] {
+		label := fmt.Sprintf("%s row %d", fileName, idx+2)
+		if len(fields) > len(header) {
+			add(topicRow{}, label, fmt.Errorf("%d fields for %d columns", len(fields), len(header)))
+			continue
+		}
+
+		var row topicRow
+		var rowErr error
+		for column, value := range fields {
+			value = strings.TrimSpace(value)
+			switch header[column] {
+			case "Subject":
+				row.Subject = value
+			case "Topic":
+				row.Topic = value
+			case "SubTopic":
+				row.SubTopic = value
+			case "Count":
+				if value != "" {
+					if row.Count, rowErr = strconv.Atoi(value); rowErr != nil {
+						rowErr = fmt.Errorf("Count %q is not a number", value)
+					}
+				}
+			case "Proficiencies":
+				row.Proficiencies = strings.Split(value, "|")
+			case "Complexities":
+				row.Complexities = strings.Split(value, "|")
+			case "Notes":
+				row.Notes = value
+			case "Language":
+				row.Language = value
+			}
+		}
+		add(row, label, rowErr)
+	}
+
+	return rows, labels, rowErrs, nil
+}
+
+// loadTopics reads the topics to generate from a CSV, JSON or YAML file,
+// chosen by extension. JSON and YAML hold a list of topics with the fields of
+// topicRow, and unknown fields are rejected. Every invalid row is reported,
+// not only the first one.
+func loadTopics(fileName string) ([]topicRow, error) {
+
+	var rows []topicRow
+	var labels []string
+	var rowErrs []error
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json", ".yaml", ".yml":
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		// misspelt fields are errors, as unknown CSV columns are
+		if strings.EqualFold(filepath.Ext(fileName), ".json") {
+			decoder := json.NewDecoder(file)
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&rows)
+		} else {
+			decoder := yaml.NewDecoder(file)
+			decoder.KnownFields(true)
+			if err = decoder.Decode(&rows); errors.Is(err, io.EOF) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		for idx := range rows {
+			labels = append(labels, fmt.Sprintf("%s entry %d", fileName, idx+1))
+		}
+		rowErrs = make([]error, len(rows))
+	default:
+		var err error
+		if rows, labels, rowErrs, err = csvTopicRows(fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	var valid []topicRow
+	var errs []error
+	seen := make(map[string]string)
+	for idx, row := range rows {
+		if rowErrs[idx] != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", labels[idx], rowErrs[idx]))
+			continue
+		}
+		if err := row.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", labels[idx], err))
+			continue
+		}
+		name := row.Subject + "-" + row.qualifiedTopic()
+		if first, ok := seen[name]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s repeats %s", labels[idx], name, first))
+			continue
+		}
+		seen[name] = labels[idx]
+		valid = append(valid, row)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("%s: no topics", fileName)
+	}
+
+	return valid, nil
+}
+
+// topicRecords turns the rows into the Subject,Topic records the scheduler
+// and the merged file work from.
+func topicRecords(rows []topicRow) [][]string {
+
+	var record [][]string
+	for _, row := range rows {
+		record = append(record, []string{row.Subject, row.qualifiedTopic()})
+	}
+
+	return record
+}
+
+// parentTopics maps the Subject-Topic key of every row with a SubTopic to the
+// key of its plain Topic, whose objectives and sources it falls back to.
+func parentTopics(rows []topicRow) map[string]string {
+
+	parents := make(map[string]string)
+	for _, row := range rows {
+		if key := row.Subject + "-" + row.qualifiedTopic(); key != row.Subject+"-"+row.Topic {
+			parents[key] = row.Subject + "-" + row.Topic
+		}
+	}
+
+	return parents
+}
+
+// withTopicGuidance appends the notes and language of a topic row to a
+// generation prompt.
+func withTopicGuidance(prompt string, row topicRow) string {
+
+	if row.Notes != "" {
+		prompt += `
+
+	Notes from the curriculum team for this Topic: ` + row.Notes
+	}
+	if row.Language != "" {
+		prompt += `
+
+	Write every Question, Answer, AllOptions and Reasoning in ` + row.Language + `, keep the JSON field names in English.`
+	}
+
+	return prompt
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadTopics(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+		wantErr []string
+	}{
+		{
+			name:    "headerless",
+			file:    "topics.csv",
+			content: "GenAI,Transformers\nML,Decision Trees\n",
+			want:    []string{"GenAI-Transformers", "ML-Decision Trees"},
+		},
+		{
+			name:    "headered",
+			file:    "topics.csv",
+			content: "Subject,Topic,SubTopic,Count,Proficiencies\nGenAI,Transformers,Attention/Multi-head,2,learner|Specialist\n",
+			want:    []string{"GenAI-Transformers - Attention - Multi-head"},
+		},
+		{
+			name:    "unknown column",
+			file:    "topics.csv",
+			content: "Subject,Topic,Proficiency\nGenAI,Transformers,Learner\n",
+			wantErr: []string{`unknown column "Proficiency"`},
+		},
+		{
+			name:    "bad count and unknown proficiency",
+			file:    "topics.csv",
+			content: "Subject,Topic,Count,Proficiencies\nGenAI,Transformers,two,\nGenAI,Diffusion,,Expert\n",
+			wantErr: []string{`row 2: Count "two" is not a number`, `row 3: unknown proficiency "Expert"`},
+		},
+		{
+			name:    "duplicate row",
+			file:    "topics.csv",
+			content: "GenAI,Transformers\nGenAI,Transformers\n",
+			wantErr: []string{"row 2: GenAI-Transformers repeats", "row 1"},
+		},
+		{
+			name:    "json",
+			file:    "topics.json",
+			content: `[{"Subject": "GenAI", "Topic": "Transformers", "Proficiencies": ["Learner"]}]`,
+			want:    []string{"GenAI-Transformers"},
+		},
+		{
+			name:    "json unknown field",
+			file:    "topics.json",
+			content: `[{"Subject": "GenAI", "Topic": "Transformers", "Proficiency": ["Learner"]}]`,
+			wantErr: []string{`unknown field "Proficiency"`},
+		},
+		{
+			name:    "yaml",
+			file:    "topics.yaml",
+			content: "- subject: GenAI\n  topic: Transformers\n  subtopic: Attention\n  complexities: [Easy]\n",
+			want:    []string{"GenAI-Transformers - Attention"},
+		},
+		{
+			name:    "yaml unknown field",
+			file:    "topics.yaml",
+			content: "- subject: GenAI\n  topic: Transformers\n  proficiency: [Learner]\n",
+			wantErr: []string{"field proficiency not found"},
+		},
+		{
+			name:    "yaml missing topic",
+			file:    "topics.yaml",
+			content: "- subject: GenAI\n",
+			wantErr: []string{"entry 1: Subject and Topic are required"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(fileName, []byte(test.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := loadTopics(fileName)
+			if len(test.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("loadTopics() = %v, want an error", rows)
+				}
+				for _, want := range test.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("loadTopics() error = %q, want it to contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTopics() error = %v", err)
+			}
+
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.Subject+"-"+row.qualifiedTopic())
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("loadTopics() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	phaseGeneration = "generation"
+	phaseValidation = "validation"
+)
+
+// modelPrice is in USD per million tokens.
+type modelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+var defaultModelPrices = map[string]modelPrice{
+	"gemini-1.5-flash":    {InputPerMillion: 0.075, OutputPerMillion: 0.30},
+	"gemini-1.5-flash-8b": {InputPerMillion: 0.0375, OutputPerMillion: 0.15},
+	"gemini-1.5-pro":      {InputPerMillion: 1.25, OutputPerMillion: 5.00},
+}
+
+func (p modelPrice) cost(promptTokens int64, candidateTokens int64) float64 {
+	return (float64(promptTokens)*p.InputPerMillion + float64(candidateTokens)*p.OutputPerMillion) / 1000000.0
+}
+
+// parseModelPrices reads prices in the form
+// "gemini-1.5-flash=0.075:0.30,gemini-1.5-flash-8b=0.0375:0.15"
+// where each value is the input and output price per million tokens.
+func parseModelPrices(value string, prices map[string]modelPrice) error {
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		llmName, priceValues, ok := strings.Cut(entry, "=")
+		inputValue, outputValue, okPrices := strings.Cut(priceValues, ":")
+		if !ok || !okPrices {
+			return fmt.Errorf("price %q: expected model=input:output", entry)
+		}
+
+		var price modelPrice
+		var err error
+		if price.InputPerMillion, err = strconv.ParseFloat(inputValue, 64); err != nil {
+			return fmt.Errorf("price %q: %w", entry, err)
+		}
+		if price.OutputPerMillion, err = strconv.ParseFloat(outputValue, 64); err != nil {
+			return fmt.Errorf("price %q: %w", entry, err)
+		}
+
+		prices[strings.TrimSpace(llmName)] = price
+	}
+
+	return nil
+}
+
+// callStats sums what a call cost over all of its attempts.
+type callStats struct {
+	Attempts        int
+	PromptTokens    int64
+	CandidateTokens int64
+	Latency         time.Duration
+}
+
+type usageKey struct {
+	Subject string
+	Topic   string
+	Phase   string
+	LLMName string
+}
+
+type tokenUsage struct {
+	Subject         string
+	Topic           string
+	Phase           string
+	LLMName         string
+	Calls           int
+	PromptTokens    int64
+	CandidateTokens int64
+	Cost            float64
+	Latency         time.Duration
+}
+
+func (u *tokenUsage) add(o tokenUsage) {
+	u.Calls += o.Calls
+	u.PromptTokens += o.PromptTokens
+	u.CandidateTokens += o.CandidateTokens
+	u.Cost += o.Cost
+	u.Latency += o.Latency
+}
+
+// usageLedger aggregates token usage per subject, topic, phase and model.
+type usageLedger struct {
+	mu      sync.Mutex
+	prices  map[string]modelPrice
+	entries map[usageKey]*tokenUsage
+}
+
+func newUsageLedger(prices map[string]modelPrice) *usageLedger {
+	return &usageLedger{
+		prices:  prices,
+		entries: make(map[usageKey]*tokenUsage),
+	}
+}
+
+func (l *usageLedger) add(phase string, subject string, topic string, llmName string, stats callStats) {
+
+	if l == nil || stats.Attempts == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := usageKey{Subject: subject, Topic: topic, Phase: phase, LLMName: llmName}
+	usage, ok := l.entries[key]
+	if !ok {
+		usage = &tokenUsage{Subject: subject, Topic: topic, Phase: phase, LLMName: llmName}
+		l.entries[key] = usage
+	}
+
+	usage.add(tokenUsage{
+		Calls:           stats.Attempts,
+		PromptTokens:    stats.PromptTokens,
+		CandidateTokens: stats.CandidateTokens,
+		Cost:            l.prices[llmName].cost(stats.PromptTokens, stats.CandidateTokens),
+		Latency:         stats.Latency,
+	})
+}
+
+// all returns the ledger rows ordered by subject, topic, phase and model.
+func (l *usageLedger) all() []tokenUsage {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rows []tokenUsage
+	for _, usage := range l.entries {
+		rows = append(rows, *usage)
+	}
+
+	slices.SortFunc(rows, func(a, b tokenUsage) int {
+		return strings.Compare(a.Subject+"|"+a.Topic+"|"+a.Phase+"|"+a.LLMName, b.Subject+"|"+b.Topic+"|"+b.Phase+"|"+b.LLMName)
+	})
+
+	return rows
+}
+
+// total sums the rows accepted by match; a nil match sums everything.
+func (l *usageLedger) total(match func(tokenUsage) bool) tokenUsage {
+
+	var total tokenUsage
+	for _, usage := range l.all() {
+		if match == nil || match(usage) {
+			total.add(usage)
+		}
+	}
+
+	return total
+}
+
+type costReport struct {
+	Totals  tokenUsage
+	ByPhase []tokenUsage
+	ByTopic []tokenUsage
+	Rows    []tokenUsage
+	Prices  map[string]modelPrice
+}
+
+func (l *usageLedger) buildReport() costReport {
+
+	report := costReport{Rows: l.all(), Prices: l.prices}
+
+	byPhase := make(map[string]*tokenUsage)
+	byTopic := make(map[string]*tokenUsage)
+	var phaseOrder, topicOrder []string
+
+	for _, usage := range report.Rows {
+		report.Totals.add(usage)
+
+		phaseKey := usage.Phase + "|" + usage.LLMName
+		if _, ok := byPhase[phaseKey]; !ok {
+			byPhase[phaseKey] = &tokenUsage{Phase: usage.Phase, LLMName: usage.LLMName}
+			phaseOrder = append(phaseOrder, phaseKey)
+		}
+		byPhase[phaseKey].add(usage)
+
+		topicKey := usage.Subject + "|" + usage.Topic
+		if _, ok := byTopic[topicKey]; !ok {
+			byTopic[topicKey] = &tokenUsage{Subject: usage.Subject, Topic: usage.Topic}
+			topicOrder = append(topicOrder, topicKey)
+		}
+		byTopic[topicKey].add(usage)
+	}
+
+	slices.Sort(phaseOrder)
+	for _, key := range phaseOrder {
+		report.ByPhase = append(report.ByPhase, *byPhase[key])
+	}
+	for _, key := range topicOrder {
+		report.ByTopic = append(report.ByTopic, *byTopic[key])
+	}
+
+	return report
+}
+
+// report prints the cost summary and persists the full report as JSON.
+func (l *usageLedger) report(fileName string) {
+
+	report := l.buildReport()
+
+	fmt.Println("Cost Report")
+	fmt.Println("----------------------------------------------------")
+	for _, usage := range report.ByPhase {
+		fmt.Printf("%-10s %-20s calls %5d  prompt %9d  candidates %9d  $%.4f\n", usage.Phase, usage.LLMName,
+			usage.Calls, usage.PromptTokens, usage.CandidateTokens, usage.Cost)
+	}
+	fmt.Println("----------------------------------------------------")
+	for _, usage := range report.ByTopic {
+		fmt.Printf("%s-%s  calls %d  tokens %d  $%.4f\n", usage.Subject, usage.Topic, usage.Calls,
+			usage.PromptTokens+usage.CandidateTokens, usage.Cost)
+	}
+	fmt.Println("----------------------------------------------------")
+	fmt.Printf("Total  calls %d  prompt %d  candidates %d  $%.4f\n", report.Totals.Calls, report.Totals.PromptTokens,
+		report.Totals.CandidateTokens, report.Totals.Cost)
+	fmt.Println("----------------------------------------------------")
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		slog.Error("cost report not written", "file", fileName, "err", err)
+		return
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		slog.Error("cost report not written", "file", fileName, "err", err)
+	}
+}